@@ -0,0 +1,69 @@
+package shlex
+
+import "strings"
+
+// AutoClose appends the runes needed to close open quotes, backquotes, substitutions and groups
+// so that the result splits without unterminated tokens (`echo "a | grep x` -> `echo "a | grep x"`).
+// Constructs are closed from the innermost to the outermost one.
+// It returns false if the input is already well-formed.
+func AutoClose(s string, opts ...Option) (string, bool) {
+	tokens, err := Tokenize(s, opts...)
+	if err != nil {
+		return s, false
+	}
+	commented := len(tokens) > 0 && tokens[len(tokens)-1].Type == COMMENT_TOKEN
+	tokens = tokens.FilterComments()
+
+	closed := strings.Builder{}
+	closed.WriteString(tokens.ClosingSuffix())
+
+	groups := make([]string, 0)
+	for _, token := range tokens {
+		if token.Type != GROUP_TOKEN {
+			continue
+		}
+		switch token.Value {
+		case "(", "{":
+			groups = append(groups, token.Value)
+		case ")", "}":
+			if len(groups) > 0 {
+				groups = groups[:len(groups)-1]
+			}
+		}
+	}
+
+	delimited := false // whether the last token ends a command so that `}` is in command position
+	if current := tokens.CurrentToken(); current.RawValue == "" && len(tokens) > 1 {
+		previous := tokens[len(tokens)-2]
+		delimited = previous.WordbreakType.IsPipelineDelimiter() || (previous.Type == GROUP_TOKEN && previous.Value == "{")
+	}
+	separated := strings.HasSuffix(s, " ")
+	if commented {
+		delimited, separated = true, true // closers go on a new line as they would be part of the comment otherwise
+	}
+	for index := len(groups) - 1; index >= 0; index-- {
+		switch groups[index] {
+		case "(":
+			if closed.Len() > 0 && strings.HasSuffix(closed.String(), "}") {
+				closed.WriteString(" ") // `}` is only a group when followed by a space
+			}
+			closed.WriteString(")")
+		case "{":
+			if !delimited || closed.Len() > 0 {
+				closed.WriteString(";")
+			}
+			if closed.Len() > 0 || !separated {
+				closed.WriteString(" ")
+			}
+			closed.WriteString("}")
+		}
+	}
+
+	if closed.Len() == 0 {
+		return s, false
+	}
+	if commented {
+		return s + "\n" + closed.String(), true
+	}
+	return s + closed.String(), true
+}
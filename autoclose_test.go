@@ -0,0 +1,54 @@
+package shlex
+
+import "testing"
+
+func TestAutoClose(t *testing.T) {
+	tests := map[string]string{
+		`echo "unfinished | grep x`: `echo "unfinished | grep x"`,
+		`echo 'a`:                   `echo 'a'`,
+		`echo $'a\`:                 `echo $'a\\'`,
+		`echo "a\`:                  `echo "a\\"`,
+		`echo a\`:                   `echo a\\`,
+		"echo `date":                "echo `date`",
+		`echo $(date`:               `echo $(date)`,
+		`echo "$(echo 'a`:           `echo "$(echo 'a')"`,
+		`(echo a`:                   `(echo a)`,
+		`(echo "a`:                  `(echo "a")`,
+		`{ echo a`:                  `{ echo a; }`,
+		`{ echo a;`:                 `{ echo a; }`,
+		`{ echo a; `:                `{ echo a; }`,
+		`{ `:                        `{ }`,
+		`( { echo a`:                `( { echo a; } )`,
+		`{ (echo a`:                 `{ (echo a); }`,
+		`{ (echo "a`:                `{ (echo "a"); }`,
+		`(echo a # c`:               "(echo a # c\n)",
+		`{ echo a # c`:              "{ echo a # c\n}",
+		`( { echo a # c`:            "( { echo a # c\n} )",
+	}
+	for s, want := range tests {
+		got, ok := AutoClose(s)
+		if !ok || got != want {
+			t.Errorf("AutoClose(%q) -> %q, %v. Want: %q, true", s, got, ok, want)
+			continue
+		}
+		tokens, err := Split(got, WithStrict(true))
+		if err != nil {
+			t.Errorf("Split(%q) -> %v", got, err)
+			continue
+		}
+		for _, token := range tokens {
+			if token.Unterminated {
+				t.Errorf("Split(%q) -> unterminated %#v", got, token)
+			}
+		}
+		if closed, ok := AutoClose(got); ok {
+			t.Errorf("AutoClose(%q) -> %q, true. Want: unchanged", got, closed)
+		}
+	}
+
+	for _, s := range []string{"", "echo a", `echo "a"`, "(echo a)", "{ echo a; }", "echo a |"} {
+		if got, ok := AutoClose(s); ok || got != s {
+			t.Errorf("AutoClose(%q) -> %q, %v. Want: %q, false", s, got, ok, s)
+		}
+	}
+}
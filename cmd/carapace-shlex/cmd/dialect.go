@@ -0,0 +1,21 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	shlex "github.com/carapace-sh/carapace-shlex"
+)
+
+// dialectNames contains the values of the dialect flag in the order of the dialects.
+var dialectNames = []string{"bash", "zsh", "powershell", "cmd", "fish"}
+
+// dialect returns the dialect with given name.
+func dialect(name string) (shlex.Dialect, error) {
+	for index, dialectName := range dialectNames {
+		if dialectName == name {
+			return shlex.Dialect(index), nil
+		}
+	}
+	return shlex.BASH_DIALECT, fmt.Errorf("unknown dialect %q (expected one of %v)", name, strings.Join(dialectNames, ", "))
+}
@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDialect(t *testing.T) {
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"--dialect", "powershell", "--format", "plain", "Write-Host `\"hi`\""}, "Write-Host\n\"hi\"\n"},
+		{[]string{"--dialect", "bash", "--format", "plain", "echo \\\"hi\\\""}, "echo\n\"hi\"\n"},
+		{[]string{"--format", "plain", "echo # comment"}, "echo\n"},
+		{[]string{"--dialect", "zsh", "--format", "plain", "echo # comment"}, "echo\n#\ncomment\n"},
+		{[]string{"--dialect", "cmd", "--format", "plain", "echo ^& 'a b'"}, "echo\n&\n'a\nb'\n"},
+		{[]string{"--dialect", "fish", "--format", "plain", `echo 'it\'s'`}, "echo\nit's\n"},
+		{[]string{"--dialect", "powershell", "--current", "--format", "plain", "ls | Select-Object `\"a b"}, "Select-Object\n\"a\nb\n"},
+		{[]string{"--dialect", "powershell", "--join", "Write-Host \"a b\" 'it''s'"}, "Write-Host 'a b' 'it''s'\n"},
+		{[]string{"--dialect", "cmd", "--prefix", "--wordbreaks", "=", "set a=b"}, "a=\n"},
+		{[]string{"--dialect", "powershell", "--format", "plain", "--wordbreaks", ",", "a,b"}, "a\n,\nb\n"},
+	}
+	for _, test := range tests {
+		output, err := execute(t, "", test.args...)
+		if err != nil {
+			t.Errorf("%v: %v", test.args, err)
+			continue
+		}
+		if output != test.want {
+			t.Errorf("%v -> %q. Want: %q", test.args, output, test.want)
+		}
+	}
+
+	_, err := execute(t, "", "--dialect", "nope", "a")
+	if err == nil {
+		t.Fatal("--dialect nope should fail")
+	}
+	for _, name := range dialectNames {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("error %q should list %v", err, name)
+		}
+	}
+
+	output, err := execute(t, "echo `\"a`\"\n", "--dialect", "powershell", "--lines", "--format", "plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.Split(output, "\n"), []string{"echo", `"a"`, ""}; !reflect.DeepEqual(got, want) {
+		t.Errorf("--lines -> %#v. Want: %#v", got, want)
+	}
+}
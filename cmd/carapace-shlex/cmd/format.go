@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	shlex "github.com/carapace-sh/carapace-shlex"
+)
+
+// formats contains the supported output formats.
+var formats = []string{"json", "jsonl", "tsv", "plain", "null"}
+
+// tsvReplacer escapes values for tab-separated output.
+var tsvReplacer = strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`)
+
+// writeTokens writes the tokens to w in given format.
+func writeTokens(w io.Writer, format string, tokens shlex.TokenSlice) error {
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetEscapeHTML(false)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(tokens)
+	case "jsonl":
+		encoder := json.NewEncoder(w)
+		encoder.SetEscapeHTML(false)
+		for _, token := range tokens {
+			if err := encoder.Encode(token); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "tsv":
+		for _, token := range tokens {
+			if _, err := fmt.Fprintf(w, "%v\t%v\t%v\n", token.Index, token.Type, tsvReplacer.Replace(token.Value)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "plain":
+		for _, token := range tokens {
+			if _, err := fmt.Fprintln(w, token.Value); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "null":
+		for _, token := range tokens {
+			if _, err := io.WriteString(w, token.Value+"\x00"); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown format %q (expected one of %v)", format, strings.Join(formats, ", "))
+	}
+}
@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/carapace-sh/carapace"
+	shlex "github.com/carapace-sh/carapace-shlex"
+	"github.com/spf13/cobra"
+)
+
+// joinStyles maps the values of the style flag to the quoting styles.
+var joinStyles = map[string]shlex.JoinStyle{
+	"default":   shlex.DEFAULT_JOIN_STYLE,
+	"minimal":   shlex.MINIMAL_JOIN_STYLE,
+	"single":    shlex.SINGLE_QUOTE_JOIN_STYLE,
+	"backslash": shlex.BACKSLASH_JOIN_STYLE,
+}
+
+var quoteCmd = &cobra.Command{
+	Use:   "quote [words...]",
+	Short: "join and quote words for use in a shell",
+	Args:  cobra.ArbitraryArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if !cmd.Flag("style").Changed {
+			fmt.Fprintln(cmd.OutOrStdout(), shlex.Join(args))
+			return nil
+		}
+
+		style, ok := joinStyles[cmd.Flag("style").Value.String()]
+		if !ok {
+			return fmt.Errorf("unknown style %q (expected one of %v)", cmd.Flag("style").Value.String(), strings.Join(joinStyleNames(), ", "))
+		}
+		fmt.Fprintln(cmd.OutOrStdout(), shlex.JoinWith(args, style))
+		return nil
+	},
+}
+
+// joinStyleNames returns the values of the style flag in the order of the quoting styles.
+func joinStyleNames() []string {
+	names := make([]string, len(joinStyles))
+	for name, style := range joinStyles {
+		names[style] = name
+	}
+	return names
+}
+
+func init() {
+	rootCmd.AddCommand(quoteCmd)
+
+	quoteCmd.Flags().String("style", "default", "quoting style (default, minimal, single, backslash)")
+
+	carapace.Gen(quoteCmd).FlagCompletion(carapace.ActionMap{
+		"style": carapace.ActionValuesDescribed(
+			"default", "double quotes",
+			"minimal", "shortest of the other styles",
+			"single", "single quotes",
+			"backslash", "backslashes",
+		),
+	})
+}
@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	shlex "github.com/carapace-sh/carapace-shlex"
+)
+
+func TestQuote(t *testing.T) {
+	tests := map[string][]string{
+		"a \"b c\" \"it's\" \"\"\n": {"quote", "a", "b c", "it's", ""},
+		"\n":                        {"quote"},
+		"\"\\$HOME\" \"a\\\"b\"\n":  {"quote", "$HOME", `a"b`},
+		"'b c' 'it'\\''s' ''\n":     {"quote", "--style", "single", "b c", "it's", ""},
+		"b\\ c it\\'s ''\n":         {"quote", "--style", "backslash", "b c", "it's", ""},
+		"'a b c d' it\\'s ''\n":     {"quote", "--style", "minimal", "a b c d", "it's", ""},
+		"\"b c\" \"it's\" \"\"\n":   {"quote", "--style", "default", "b c", "it's", ""},
+		"-n -- --style\n":           {"quote", "--", "-n", "--", "--style"},
+	}
+	for want, args := range tests {
+		output, err := execute(t, "", args...)
+		if err != nil {
+			t.Errorf("%v: %v", args, err)
+			continue
+		}
+		if output != want {
+			t.Errorf("%v -> %q. Want: %q", args, output, want)
+		}
+
+		words, err := shlex.Split(strings.TrimSuffix(output, "\n"))
+		if err != nil {
+			t.Error(err)
+		}
+		got := words.Strings()
+		if len(args) > 1 && args[1] == "--style" {
+			args = args[2:]
+		}
+		if args = args[1:]; len(args) > 0 && args[0] == "--" {
+			args = args[1:]
+		}
+		if len(args) == 0 {
+			args = []string{""} // empty word at the end of the input
+		}
+		if !reflect.DeepEqual(got, args) {
+			t.Errorf("Split(%q) -> %#v. Want: %#v", output, got, args)
+		}
+	}
+
+	if _, err := execute(t, "", "quote", "--style", "unknown", "a"); err == nil {
+		t.Error("quote --style unknown should fail")
+	}
+}
+
+func TestUnquote(t *testing.T) {
+	tests := map[string]string{
+		`'it'\''s'`: "it's\n",
+		`"a b"`:     "a b\n",
+		`a\ b`:      "a b\n",
+		`$'a\tb'`:   "a\tb\n",
+		`plain`:     "plain\n",
+		`''`:        "\n",
+	}
+	for arg, want := range tests {
+		output, err := execute(t, "", "unquote", arg)
+		if err != nil {
+			t.Errorf("unquote %q: %v", arg, err)
+			continue
+		}
+		if output != want {
+			t.Errorf("unquote %q -> %q. Want: %q", arg, output, want)
+		}
+	}
+
+	for _, arg := range []string{`a b`, `'a`, `a\`, `a | b`} {
+		if _, stderr, err := executeStderr(t, "", "unquote", arg); err == nil {
+			t.Errorf("unquote %q should fail", arg)
+		} else if arg == `'a` && !strings.Contains(stderr, "^") {
+			t.Errorf("unquote %q -> stderr %q. Want: caret", arg, stderr)
+		}
+	}
+
+	if _, err := execute(t, "", "unquote"); err == nil {
+		t.Error("unquote without argument should fail")
+	}
+}
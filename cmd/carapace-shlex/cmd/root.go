@@ -2,7 +2,12 @@ package cmd
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
 
 	"github.com/carapace-sh/carapace"
 	"github.com/carapace-sh/carapace-bridge/pkg/actions/bridge"
@@ -16,10 +21,71 @@ var rootCmd = &cobra.Command{
 	CompletionOptions: cobra.CompletionOptions{
 		DisableDefaultCmd: true,
 	},
-	Args: cobra.ExactArgs(1),
+	Args: func(cmd *cobra.Command, args []string) error {
+		if cmd.Flag("file").Changed || cmd.Flag("lines").Changed {
+			return cobra.NoArgs(cmd, args)
+		}
+		return cobra.MaximumNArgs(1)(cmd, args)
+	},
 	RunE: func(cmd *cobra.Command, args []string) error {
-		tokens, err := shlex.Split(args[0])
+		opts := make([]shlex.Option, 0)
+		d, err := dialect(cmd.Flag("dialect").Value.String())
+		if err != nil {
+			return err
+		}
+		opts = append(opts, shlex.WithDialect(d)) // before other options so these can override the dialect
+		if cmd.Flag("wordbreaks").Changed {
+			opts = append(opts, shlex.WithWordbreaks(cmd.Flag("wordbreaks").Value.String()))
+		}
+		if cmd.Flag("strict").Changed {
+			opts = append(opts, shlex.WithStrict(true))
+		}
+		if cmd.Flag("max-token-length").Changed {
+			maxTokenLength, _ := cmd.Flags().GetInt("max-token-length")
+			opts = append(opts, shlex.WithMaxTokenLength(maxTokenLength))
+		}
+		if cmd.Flag("expand").Changed {
+			opts = append(opts, shlex.WithVarExpansion(os.LookupEnv))
+		}
+
+		if cmd.Flag("file").Changed {
+			return splitFile(cmd, cmd.Flag("file").Value.String(), opts)
+		}
+		if cmd.Flag("lines").Changed {
+			return splitFile(cmd, "-", opts)
+		}
+
+		if len(args) == 0 { // read the input from stdin
+			input, err := io.ReadAll(cmd.InOrStdin())
+			if err != nil {
+				return err
+			}
+			args = []string{strings.TrimSuffix(strings.TrimSuffix(string(input), "\n"), "\r")}
+		}
+
+		split := shlex.Split
+		if cmd.Flag("spaces").Changed {
+			opts = append(opts, shlex.WithSpaceTokens(true))
+		}
+		if cmd.Flag("comments").Changed || cmd.Flag("spaces").Changed {
+			split = shlex.Tokenize
+		}
+		if cmd.Flag("cursor").Changed {
+			cursor, _ := cmd.Flags().GetInt("cursor")
+			if cursor < 0 || cursor > len(args[0]) {
+				fmt.Fprintf(cmd.ErrOrStderr(), "warning: cursor %v is out of range [0, %v]\n", cursor, len(args[0]))
+			}
+			split = func(s string, opts ...shlex.Option) (shlex.TokenSlice, error) {
+				return shlex.SplitByteCursor(s, cursor, opts...)
+			}
+		}
+
+		tokens, err := split(args[0], opts...)
 		if err != nil {
+			var lexError *shlex.LexError
+			if errors.As(err, &lexError) {
+				printCaret(cmd.ErrOrStderr(), args[0], lexError.Index)
+			}
 			return err
 		}
 
@@ -34,25 +100,105 @@ var rootCmd = &cobra.Command{
 		}
 
 		switch {
+		case cmd.Flag("current-word").Changed:
+			encoder := json.NewEncoder(cmd.OutOrStdout())
+			encoder.SetEscapeHTML(false)
+			encoder.SetIndent("", "  ")
+			return encoder.Encode(struct {
+				Index int
+				Word  *shlex.Token
+			}{
+				Index: tokens.CurrentWordIndex(),
+				Word:  tokens.LastWord(),
+			})
+		case cmd.Flag("state").Changed:
+			fmt.Fprintln(cmd.OutOrStdout(), tokens.EndState())
+			return nil
 		case cmd.Flag("prefix").Changed:
+			if cmd.Flag("wordbreaks").Changed {
+				fmt.Fprintln(cmd.OutOrStdout(), tokens.WordbreakPrefixWith(cmd.Flag("wordbreaks").Value.String()))
+				return nil
+			}
 			fmt.Fprintln(cmd.OutOrStdout(), tokens.WordbreakPrefix())
 			return nil
+		case cmd.Flag("suffix").Changed:
+			if cmd.Flag("wordbreaks").Changed {
+				fmt.Fprintln(cmd.OutOrStdout(), tokens.WordbreakSuffixWith(cmd.Flag("wordbreaks").Value.String()))
+				return nil
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), tokens.WordbreakSuffix())
+			return nil
 		case cmd.Flag("join").Changed:
 			words := make([]string, 0)
 			for _, word := range tokens.Words() {
 				words = append(words, word.Value)
 			}
+			if cmd.Flag("dialect").Changed {
+				fmt.Fprintln(cmd.OutOrStdout(), shlex.JoinDialect(words, d))
+				return nil
+			}
 			fmt.Fprintln(cmd.OutOrStdout(), shlex.Join(words))
 			return nil
 		default:
-			encoder := json.NewEncoder(cmd.OutOrStdout())
-			encoder.SetEscapeHTML(false)
-			encoder.SetIndent("", "  ")
-			return encoder.Encode(tokens)
+			return writeTokens(cmd.OutOrStdout(), cmd.Flag("format").Value.String(), tokens)
 		}
 	},
 }
 
+// splitFile prints the tokens of each logical line of the file ("-" for stdin).
+func splitFile(cmd *cobra.Command, path string, opts []shlex.Option) error {
+	r := cmd.InOrStdin()
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	lines, err := shlex.SplitLines(r, opts...)
+	if err != nil {
+		return err
+	}
+	if cmd.Flag("words").Changed {
+		for index, line := range lines {
+			lines[index] = line.Words()
+		}
+	}
+
+	if format := cmd.Flag("format").Value.String(); format != "json" {
+		for _, line := range lines { // the tokens of all lines in sequence
+			if err := writeTokens(cmd.OutOrStdout(), format, line); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	encoder := json.NewEncoder(cmd.OutOrStdout())
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(lines)
+}
+
+// printCaret prints the line of the input containing given rune index with a caret under it.
+func printCaret(w io.Writer, input string, index int) {
+	runes := []rune(input)
+	if index > len(runes) {
+		index = len(runes)
+	}
+	prefix := string(runes[:index])
+	lineStart := strings.LastIndex(prefix, "\n") + 1
+	line := input[lineStart:]
+	if lineEnd := strings.Index(line, "\n"); lineEnd >= 0 {
+		line = line[:lineEnd]
+	}
+	column := utf8.RuneCountInString(prefix[lineStart:])
+	fmt.Fprintln(w, line)
+	fmt.Fprintln(w, strings.Repeat(" ", column)+"^")
+}
+
 func Execute(version string) error {
 	rootCmd.Version = version
 	return rootCmd.Execute()
@@ -60,16 +206,43 @@ func Execute(version string) error {
 
 func init() {
 	rootCmd.Flags().Bool("args", false, "show words")
+	rootCmd.Flags().Bool("comments", false, "include comments")
+	rootCmd.Flags().String("file", "", "split each line of a file (\"-\" for stdin)")
 	rootCmd.Flags().Bool("current", false, "show current pipeline")
+	rootCmd.Flags().Bool("current-word", false, "show current word and its index")
+	rootCmd.Flags().Int("cursor", 0, "split up to the cursor (byte offset)")
+	rootCmd.Flags().String("dialect", "bash", "shell dialect (bash, zsh, powershell, cmd, fish)")
+	rootCmd.Flags().Bool("expand", false, "expand environment variables")
+	rootCmd.Flags().String("format", "json", "output format (json, jsonl, tsv, plain, null)")
 	rootCmd.Flags().Bool("prefix", false, "show wordbreak prefix")
+	rootCmd.Flags().Bool("spaces", false, "include spaces and comments")
+	rootCmd.Flags().Bool("state", false, "show lexer state at the end of the input")
+	rootCmd.Flags().Bool("suffix", false, "show wordbreak suffix")
+	rootCmd.Flags().Bool("strict", false, "return an error for unclosed quotes and trailing escapes")
 	rootCmd.Flags().Bool("words", false, "show words")
 	rootCmd.Flags().Bool("join", false, "re-join words")
+	rootCmd.Flags().Bool("lines", false, "split each line of stdin")
+	rootCmd.Flags().Int("max-token-length", 0, "maximum runes of a token (0 is unlimited)")
+	rootCmd.Flags().String("wordbreaks", "", "wordbreak runes (default: $COMP_WORDBREAKS)")
 
+	rootCmd.MarkFlagsMutuallyExclusive("file", "lines")
+	for _, name := range []string{"comments", "spaces", "file", "lines"} {
+		rootCmd.MarkFlagsMutuallyExclusive("cursor", name)
+	}
 	rootCmd.MarkFlagsMutuallyExclusive(
+		"current-word",
 		"join",
 		"prefix",
+		"state",
+		"suffix",
 	)
 
+	carapace.Gen(rootCmd).FlagCompletion(carapace.ActionMap{
+		"dialect": carapace.ActionValues(dialectNames...),
+		"file":    carapace.ActionFiles(),
+		"format":  carapace.ActionValues(formats...),
+	})
+
 	carapace.Gen(rootCmd).PositionalCompletion(
 		bridge.ActionCarapaceBin().SplitP(),
 	)
@@ -0,0 +1,230 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/pflag"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+// sample is the command line of the usage example in the README.
+const sample = `git commit -m 'fix: a\tb' | tee out\ file.txt`
+
+// execute runs the root command with given stdin and arguments and returns its output.
+func execute(t *testing.T, stdin string, args ...string) (string, error) {
+	t.Helper()
+	stdout, _, err := executeStderr(t, stdin, args...)
+	return stdout, err
+}
+
+// executeStderr is like execute but additionally returns the output on stderr.
+func executeStderr(t *testing.T, stdin string, args ...string) (string, string, error) {
+	t.Helper()
+	for _, c := range append(rootCmd.Commands(), rootCmd) {
+		c.Flags().VisitAll(func(f *pflag.Flag) { // flags keep their value between executions
+			f.Value.Set(f.DefValue)
+			f.Changed = false
+		})
+	}
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	rootCmd.SetIn(strings.NewReader(stdin))
+	rootCmd.SetOut(stdout)
+	rootCmd.SetErr(stderr)
+	rootCmd.SetArgs(args)
+	err := rootCmd.Execute()
+	return stdout.String(), stderr.String(), err
+}
+
+// values decodes the values of the printed tokens.
+func values(t *testing.T, output string) []string {
+	t.Helper()
+	var tokens []struct{ Value string }
+	if err := json.Unmarshal([]byte(output), &tokens); err != nil {
+		t.Fatalf("%v: %v", err, output)
+	}
+	values := make([]string, 0)
+	for _, token := range tokens {
+		values = append(values, token.Value)
+	}
+	return values
+}
+
+func TestStdin(t *testing.T) {
+	tests := map[string][]string{
+		"git commit -m \"wip\"\n": {"git", "commit", "-m", "wip"},
+		"echo 'it''s' \"a b\"":    {"echo", "its", "a b"},
+		"echo a\r\n":              {"echo", "a"},
+		"echo a \\\n  b\n":        {"echo", "a", "b"},
+		"":                        {""},
+		"echo a\n\n":              {"echo", "a", ""},
+	}
+	for stdin, want := range tests {
+		output, err := execute(t, stdin, "--words")
+		if err != nil {
+			t.Errorf("stdin %q: %v", stdin, err)
+			continue
+		}
+		if got := values(t, output); !reflect.DeepEqual(got, want) {
+			t.Errorf("stdin %q -> %#v. Want: %#v", stdin, got, want)
+		}
+	}
+
+	output, err := execute(t, "ignored", "--words", "echo a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := values(t, output), []string{"echo", "a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("argument -> %#v. Want: %#v", got, want)
+	}
+
+	output, err = execute(t, "echo \"a b\"\n", "--join")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "echo \"a b\"\n"; output != want {
+		t.Errorf("--join -> %q. Want: %q", output, want)
+	}
+}
+
+func TestStdinLines(t *testing.T) {
+	output, err := execute(t, "echo a\nls -l 'x y'\n", "--lines", "--words")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lines [][]struct{ Value string }
+	if err := json.Unmarshal([]byte(output), &lines); err != nil {
+		t.Fatalf("%v: %v", err, output)
+	}
+	got := make([][]string, 0)
+	for _, line := range lines {
+		values := make([]string, 0)
+		for _, token := range line {
+			values = append(values, token.Value)
+		}
+		got = append(got, values)
+	}
+	if want := [][]string{{"echo", "a"}, {"ls", "-l", "x y"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("--lines -> %#v. Want: %#v", got, want)
+	}
+}
+
+func TestCursor(t *testing.T) {
+	tests := []struct {
+		args    []string
+		want    []string
+		warning bool
+	}{
+		{[]string{"--cursor", "6", "git checkout main"}, []string{"git", "ch"}, false},
+		{[]string{"--cursor", "4", "git checkout main"}, []string{"git", ""}, false},
+		{[]string{"--cursor", "0", "git checkout main"}, []string{""}, false},
+		{[]string{"--cursor", "17", "git checkout main"}, []string{"git", "checkout", "main"}, false},
+		{[]string{"--cursor", "99", "git checkout main"}, []string{"git", "checkout", "main"}, true},
+		{[]string{"--cursor", "-1", "git checkout main"}, []string{""}, true},
+		{[]string{"--cursor", "8", "echo 'äö' x"}, []string{"echo", "ä"}, false},
+		{[]string{"--cursor", "9", "echo 'äö' x"}, []string{"echo", "ä"}, false}, // within `ö`
+		{[]string{"--cursor", "10", "echo 'äö' x"}, []string{"echo", "äö"}, false},
+		{[]string{"--cursor", "6", "--current", "ls | grep x"}, []string{"g"}, false},
+	}
+	for _, test := range tests {
+		output, stderr, err := executeStderr(t, "", test.args...)
+		if err != nil {
+			t.Errorf("%v: %v", test.args, err)
+			continue
+		}
+		if got := values(t, output); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v -> %#v. Want: %#v", test.args, got, test.want)
+		}
+		if got := strings.Contains(stderr, "warning"); got != test.warning {
+			t.Errorf("%v -> stderr %q. Want warning: %v", test.args, stderr, test.warning)
+		}
+	}
+
+	output, err := execute(t, "", "--cursor", "7", "--prefix", "dd if=/tmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "if=\n"; output != want {
+		t.Errorf("--prefix -> %q. Want: %q", output, want)
+	}
+}
+
+func TestArgs(t *testing.T) {
+	for _, args := range [][]string{
+		{"a", "b"},
+		{"--lines", "a"},
+		{"--file", "-", "a"},
+		{"--file", "-", "--lines"},
+		{"--cursor", "1", "--spaces", "a"},
+	} {
+		if _, err := execute(t, "", args...); err == nil {
+			t.Errorf("%v should fail", args)
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	for _, format := range formats {
+		output, err := execute(t, "", "--words", "--format", format, sample)
+		if err != nil {
+			t.Errorf("--format %v: %v", format, err)
+			continue
+		}
+
+		golden := filepath.Join("testdata", "sample."+format)
+		if *update {
+			if err := os.WriteFile(golden, []byte(output), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		want, err := os.ReadFile(golden)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if output != string(want) {
+			t.Errorf("--format %v -> %q. Want: %q", format, output, want)
+		}
+	}
+
+	tests := map[string]string{
+		"plain": "tee\nout file.txt\n",
+		"null":  "tee\x00out file.txt\x00",
+		"tsv":   "28\tWORD_TOKEN\ttee\n32\tWORD_TOKEN\tout file.txt\n",
+	}
+	for format, want := range tests {
+		output, err := execute(t, "", "--current", "--format", format, sample)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if output != want {
+			t.Errorf("--current --format %v -> %q. Want: %q", format, output, want)
+		}
+	}
+
+	output, err := execute(t, "", "--format", "plain", "echo \"a\tb\nc\"")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "echo\na\tb\nc\n"; output != want {
+		t.Errorf("--format plain -> %q. Want: %q", output, want)
+	}
+	output, err = execute(t, "", "--format", "tsv", "echo \"a\tb\nc\\\\\"")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "0\tWORD_TOKEN\techo\n5\tWORD_TOKEN\ta\\tb\\nc\\\\\n"; output != want {
+		t.Errorf("--format tsv -> %q. Want: %q", output, want)
+	}
+
+	if _, err := execute(t, "", "--format", "xml", "echo"); err == nil {
+		t.Error("--format xml should fail")
+	}
+}
@@ -0,0 +1,31 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	shlex "github.com/carapace-sh/carapace-shlex"
+	"github.com/spf13/cobra"
+)
+
+var unquoteCmd = &cobra.Command{
+	Use:   "unquote <word>",
+	Short: "print the value of a single quoted word",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		value, err := shlex.Unquote(args[0])
+		if err != nil {
+			var lexError *shlex.LexError
+			if errors.As(err, &lexError) {
+				printCaret(cmd.ErrOrStderr(), args[0], lexError.Index)
+			}
+			return err
+		}
+		fmt.Fprintln(cmd.OutOrStdout(), value)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(unquoteCmd)
+}
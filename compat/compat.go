@@ -0,0 +1,72 @@
+// Package compat provides the API of github.com/google/shlex on top of carapace-shlex.
+//
+// It can replace the original package in imports:
+//
+//	import shlex "github.com/carapace-sh/carapace-shlex/compat"
+//
+// Words are only separated by spaces, quotes and escapes. Pipeline delimiters, redirections, groups and
+// substitutions are not recognized and comments start with an unquoted `#` at the start of a word.
+package compat
+
+import (
+	"io"
+	"strings"
+
+	shlex "github.com/carapace-sh/carapace-shlex"
+)
+
+// Lexer turns an input stream into a sequence of words. Whitespace and comments are skipped.
+type Lexer struct {
+	lexer *shlex.Lexer
+}
+
+// NewLexer creates a new lexer from an input stream.
+func NewLexer(r io.Reader) *Lexer {
+	return &Lexer{shlex.NewLexer(r, options()...)}
+}
+
+// Next returns the next word, or an error. If there are no more words,
+// the error will be io.EOF.
+func (l *Lexer) Next() (string, error) {
+	for {
+		token, err := l.lexer.Next()
+		if err != nil {
+			return "", err
+		}
+		if token.RawValue != "" { // skip the empty word at the end of the input
+			return token.Value, nil
+		}
+	}
+}
+
+// Split partitions a string into a slice of strings.
+func Split(s string) ([]string, error) {
+	l := &Lexer{shlex.NewLexerString(s, options()...)}
+	subStrings := make([]string, 0)
+	for {
+		word, err := l.Next()
+		if err != nil {
+			if err == io.EOF {
+				return subStrings, nil
+			}
+			return subStrings, err
+		}
+		subStrings = append(subStrings, word)
+	}
+}
+
+// options configures the tokenizer to behave like github.com/google/shlex.
+func options() []shlex.Option {
+	classifier := shlex.NewDefaultClassifier()
+	for r := range classifier {
+		if !strings.ContainsRune(" \t\r\n\"'\\#", r) {
+			delete(classifier, r) // dollar, backquote and wordbreak runes
+		}
+	}
+	return []shlex.Option{
+		shlex.WithClassifier(classifier),
+		shlex.WithGroups(false),
+		shlex.WithLineContinuation(false),
+		shlex.WithStrict(true), // unclosed quotes and trailing escapes are errors
+	}
+}
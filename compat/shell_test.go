@@ -0,0 +1,35 @@
+package compat
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestShellSyntax(t *testing.T) {
+	tests := map[string][]string{
+		"":                     {},
+		"a ":                   {"a"},
+		"a|b && c;d &":         {"a|b", "&&", "c;d", "&"},
+		"echo $(a b) `c d`":    {"echo", "$(a", "b)", "`c", "d`"},
+		"(a) {b}":              {"(a)", "{b}"},
+		"cat <<EOF\nx\nEOF":    {"cat", "<<EOF", "x", "EOF"},
+		"a=b:c":                {"a=b:c"},
+		"a\\\nb \"c\\\nd\"":    {"a\nb", "c\nd"},
+		"echo $'a\\tb' # c\nd": {"echo", "$a\\tb", "d"},
+	}
+	for s, want := range tests {
+		got, err := Split(s)
+		if err != nil {
+			t.Error(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Split(%q) -> %#v. Want: %#v", s, got, want)
+		}
+	}
+
+	for _, s := range []string{"'a", "\"a", "a\\"} {
+		if _, err := Split(s); err == nil {
+			t.Errorf("Split(%q) should fail", s)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package shlex
+
+import "unicode/utf8"
+
+// CompletionContext describes what is being completed at the end of a command line (see Parse).
+type CompletionContext struct {
+	Command     string   // command word of the current pipeline (empty while the command itself is being completed)
+	Args        []string // arguments between the command and the current word
+	CurrentWord Token    // word being completed (empty after a trailing space)
+	Prefix      string   // prefix of the current word up to the last wordbreak (see WordbreakPrefix)
+	OpenQuote   rune     // quote rune the current word ends within (0 if none)
+	DashDash    bool     // whether the current word follows the end-of-options marker (`--`)
+}
+
+// Parse splits the command line up to the cursor and returns the context for completing its last word.
+// Leading keywords (`if`, `time`, ...), variable assignments and redirects of the current pipeline are skipped,
+// so the command is the one actually being run (`sudo`-like precommands are kept, see StripPrecommand).
+func Parse(line string, opts ...Option) (*CompletionContext, error) {
+	tokens, err := Split(line, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		tokens = append(tokens, Token{Type: WORD_TOKEN, State: START_STATE})
+	}
+
+	words := tokens.SkipKeywords()
+	context := &CompletionContext{
+		Args:        make([]string, 0),
+		CurrentWord: words[len(words)-1],
+		Prefix:      tokens.WordbreakPrefix(),
+		DashDash:    tokens.AfterDashDash() != nil,
+	}
+	if len(words) > 1 {
+		context.Command = words[0].Value
+		context.Args = words[1 : len(words)-1].Strings()
+	}
+
+	switch current := tokens.CurrentToken(); current.State {
+	case QUOTING_STATE, QUOTING_ESCAPING_STATE, ESCAPING_QUOTED_STATE, ANSI_C_QUOTING_STATE, ESCAPING_ANSI_C_STATE,
+		BACKQUOTING_STATE, ESCAPING_BACKQUOTED_STATE:
+		context.OpenQuote, _ = utf8.DecodeLastRuneInString(current.Quote)
+	}
+	return context, nil
+}
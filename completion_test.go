@@ -0,0 +1,55 @@
+package shlex
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	type context struct {
+		Command   string
+		Args      []string
+		Current   string
+		Prefix    string
+		OpenQuote rune
+		DashDash  bool
+	}
+	tests := map[string]context{
+		``:                                 {Args: []string{}},
+		`gi`:                               {Args: []string{}, Current: "gi"},
+		`git `:                             {Command: "git", Args: []string{}},
+		`git checkout ma`:                  {Command: "git", Args: []string{"checkout"}, Current: "ma"},
+		`git checkout `:                    {Command: "git", Args: []string{"checkout"}},
+		`git commit -m "fix `:              {Command: "git", Args: []string{"commit", "-m"}, Current: "fix ", Prefix: "", OpenQuote: '"'},
+		`echo 'a b`:                        {Command: "echo", Args: []string{}, Current: "a b", OpenQuote: '\''},
+		`echo $'a`:                         {Command: "echo", Args: []string{}, Current: "a", OpenQuote: '\''},
+		"echo `dat":                        {Command: "echo", Args: []string{}, Current: "`dat", OpenQuote: '`'},
+		`echo "a" b`:                       {Command: "echo", Args: []string{"a"}, Current: "b"},
+		`ls | `:                            {Args: []string{}},
+		`ls | gr`:                          {Args: []string{}, Current: "gr"},
+		`ls -la | grep -i `:                {Command: "grep", Args: []string{"-i"}},
+		`a && b; FOO=1 make -j`:            {Command: "make", Args: []string{}, Current: "-j"},
+		`if systemctl sta`:                 {Command: "systemctl", Args: []string{}, Current: "sta"},
+		`cat < in.txt > out.txt -`:         {Command: "cat", Args: []string{}, Current: "-"},
+		`kubectl exec pod -- l`:            {Command: "kubectl", Args: []string{"exec", "pod", "--"}, Current: "l", DashDash: true},
+		`kubectl exec pod --`:              {Command: "kubectl", Args: []string{"exec", "pod"}, Current: "--"},
+		`dd if=/tmp/f`:                     {Command: "dd", Args: []string{}, Current: "if=/tmp/f", Prefix: "if="},
+		`git --git-dir=/repo checkout fea`: {Command: "git", Args: []string{"--git-dir=/repo", "checkout"}, Current: "fea"},
+		`sudo -u root systemctl rest`:      {Command: "sudo", Args: []string{"-u", "root", "systemctl"}, Current: "rest"},
+	}
+	for s, want := range tests {
+		c, err := Parse(s, WithWordbreaks(`"'><=;|&(:`))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		got := context{c.Command, c.Args, c.CurrentWord.Value, c.Prefix, c.OpenQuote, c.DashDash}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Parse(%q) -> %#v. Want: %#v", s, got, want)
+		}
+	}
+
+	if _, err := Parse(`echo "a`, WithStrict(true)); err == nil {
+		t.Error("Parse with WithStrict should fail for an unclosed quote")
+	}
+}
@@ -0,0 +1,65 @@
+package shlex
+
+import "encoding/json"
+
+// Dialect is a shell specific lexing profile.
+type Dialect int
+
+const (
+	BASH_DIALECT       Dialect = iota // default
+	ZSH_DIALECT                       // bash without comments (`INTERACTIVE_COMMENTS` is disabled by default)
+	POWERSHELL_DIALECT                // backtick as escape rune and doubled quotes within quotes
+	CMD_DIALECT                       // caret as escape rune and only non-escaping double quotes
+	FISH_DIALECT                      // escape sequences outside of quotes and restricted escapes within quotes
+)
+
+var dialects = map[Dialect]string{
+	BASH_DIALECT:       "BASH_DIALECT",
+	ZSH_DIALECT:        "ZSH_DIALECT",
+	POWERSHELL_DIALECT: "POWERSHELL_DIALECT",
+	CMD_DIALECT:        "CMD_DIALECT",
+	FISH_DIALECT:       "FISH_DIALECT",
+}
+
+func (d Dialect) MarshalJSON() ([]byte, error) {
+	return json.Marshal(dialects[d])
+}
+
+// options returns the options configuring the dialect.
+func (d Dialect) options() []Option {
+	switch d {
+	case ZSH_DIALECT:
+		return []Option{WithComments(false)}
+	case POWERSHELL_DIALECT:
+		return []Option{
+			WithEscapeRunes("`"),
+			func(c *config) {
+				c.doubledQuotes = true
+				c.dollarQuotes = false
+			},
+		}
+	case CMD_DIALECT:
+		return []Option{
+			WithComments(false),
+			WithEscapeRunes("^"),
+			WithEscapingQuotes(""),
+			WithNonEscapingQuotes(`"`),
+			func(c *config) {
+				c.runeClasses = append(c.runeClasses,
+					runeClass{"", DOLLAR_RUNE_CLASS},
+					runeClass{"", BACKQUOTE_RUNE_CLASS},
+				)
+			},
+		}
+	case FISH_DIALECT:
+		return []Option{
+			WithGroups(false), // parentheses are command substitutions and braces are expanded
+			func(c *config) {
+				c.dollarQuotes = false
+				c.runeClasses = append(c.runeClasses, runeClass{"", BACKQUOTE_RUNE_CLASS})
+			},
+		}
+	default:
+		return []Option{}
+	}
+}
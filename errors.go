@@ -0,0 +1,105 @@
+package shlex
+
+import "fmt"
+
+// LexError is returned by the tokenizer when it fails at a position of the input.
+// Strict mode errors (UnclosedQuoteError, UnclosedSubstitutionError, TrailingEscapeError) and read errors are wrapped.
+type LexError struct {
+	Index int        // rune index at which the error occurred
+	State LexerState // state of the tokenizer
+	Msg   string
+	Err   error // wrapped error (if any)
+}
+
+func (e *LexError) Error() string {
+	if e.Err != nil {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%v at index %v (%v)", e.Msg, e.Index, e.State)
+}
+
+func (e *LexError) Unwrap() error {
+	return e.Err
+}
+
+// UnclosedQuoteError is returned in strict mode when the input ends within a quoted string.
+type UnclosedQuoteError struct {
+	Index int  // rune index of the opening quote
+	Quote rune // the opening quote (`"`, `'` or "`")
+}
+
+func (e *UnclosedQuoteError) Error() string {
+	return fmt.Sprintf("unclosed quote %q at index %v", e.Quote, e.Index)
+}
+
+// UnclosedSubstitutionError is returned in strict mode when the input ends within a command substitution,
+// process substitution or arithmetic expansion.
+type UnclosedSubstitutionError struct {
+	Index      int // rune index of the substitution (`$(`, `<(`, `>(` or `$((`)
+	QuoteIndex int // rune index of the enclosing double quote (-1 if there is none)
+}
+
+func (e *UnclosedSubstitutionError) Error() string {
+	return fmt.Sprintf("unclosed substitution at index %v", e.Index)
+}
+
+// TrailingEscapeError is returned in strict mode when the input ends with an escape rune.
+type TrailingEscapeError struct {
+	Index int // rune index of the escape rune
+}
+
+func (e *TrailingEscapeError) Error() string {
+	return fmt.Sprintf("trailing escape at index %v", e.Index)
+}
+
+// InvalidUTF8Error is returned with ERROR_ON_INVALID_UTF8 when the input contains invalid UTF-8.
+type InvalidUTF8Error struct {
+	Offset int // byte offset of the invalid byte
+}
+
+func (e *InvalidUTF8Error) Error() string {
+	return fmt.Sprintf("invalid UTF-8 at byte offset %v", e.Offset)
+}
+
+// TokenTooLongError is returned when a token exceeds the limit set by WithMaxTokenLength.
+type TokenTooLongError struct {
+	Index int // rune index of the token
+	Limit int // the maximum token length
+}
+
+func (e *TokenTooLongError) Error() string {
+	return fmt.Sprintf("token at index %v exceeds maximum length of %v", e.Index, e.Limit)
+}
+
+// TooManyTokensError is returned when the input contains more tokens than allowed by WithMaxTokens.
+type TooManyTokensError struct {
+	Index int // rune index of the first token beyond the limit
+	Limit int // the maximum number of tokens
+}
+
+func (e *TooManyTokensError) Error() string {
+	return fmt.Sprintf("token at index %v exceeds maximum of %v tokens", e.Index, e.Limit)
+}
+
+// LineError is returned by SplitLines for an error within a line of the input.
+type LineError struct {
+	Line int // line number (starting at 1)
+	Err  error
+}
+
+func (e *LineError) Error() string {
+	return fmt.Sprintf("line %v: %v", e.Line, e.Err)
+}
+
+func (e *LineError) Unwrap() error {
+	return e.Err
+}
+
+// MultipleWordsError is returned by Unquote when the input contains more than one word.
+type MultipleWordsError struct {
+	Index int // rune index of the second word
+}
+
+func (e *MultipleWordsError) Error() string {
+	return fmt.Sprintf("multiple words (second at index %v)", e.Index)
+}
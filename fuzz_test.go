@@ -0,0 +1,35 @@
+//go:build go1.18
+// +build go1.18
+
+package shlex
+
+import (
+	"testing"
+)
+
+func FuzzReconstruct(f *testing.F) {
+	f.Add(testString)
+	f.Add("cat <<-EOF # c\n\ta $b\n\tEOF\nls 2>&1 | (grep -v 'x y' && echo \"$(id)\") &")
+	f.Add("echo $'a\\tb' `id` ~/a* !! \\\n")
+	f.Add("echo 'unclosed")
+	f.Add("echo a\xc0\xafb \"\xe2\x82\" \x80")
+	f.Fuzz(func(t *testing.T, s string) {
+		passThrough := WithInvalidUTF8(PASS_THROUGH_INVALID_UTF8)
+		tokens, err := Tokenize(s, WithSpaceTokens(true), passThrough)
+		if err != nil {
+			return
+		}
+		if got := tokens.Reconstruct(); got != s {
+			t.Errorf("Tokenize(%q).Reconstruct() -> %q", s, got)
+		}
+
+		if last := tokens[len(tokens)-1]; last.Type == COMMENT_TOKEN {
+			return // trailing comment is skipped by Split
+		}
+		if tokens, err = Split(s, passThrough); err != nil {
+			t.Errorf("Split(%q) -> %v", s, err)
+		} else if got := tokens.Reconstruct(); got != s {
+			t.Errorf("Split(%q).Reconstruct() -> %q", s, got)
+		}
+	})
+}
@@ -0,0 +1,160 @@
+package shlex
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// IssueKind is the kind of a problem found by TokenSlice.Validate.
+type IssueKind int
+
+const (
+	ISSUE_UNCLOSED_SINGLE_QUOTE     IssueKind = iota // the input ends within single quotes (`'...` or `$'...`)
+	ISSUE_UNCLOSED_DOUBLE_QUOTE                      // the input ends within double quotes (`"...`)
+	ISSUE_TRAILING_ESCAPE                            // the input ends with an escape rune
+	ISSUE_TRAILING_PIPE                              // the input ends with `|`, `|&`, `&&` or `||`
+	ISSUE_EMPTY_COMMAND_BEFORE_PIPE                  // `|`, `|&`, `&&` or `||` without a command before it
+	ISSUE_UNCLOSED_SUBSTITUTION                      // the input ends within a substitution or arithmetic expansion (`$(...`)
+)
+
+var issueKinds = map[IssueKind]string{
+	ISSUE_UNCLOSED_SINGLE_QUOTE:     "ISSUE_UNCLOSED_SINGLE_QUOTE",
+	ISSUE_UNCLOSED_DOUBLE_QUOTE:     "ISSUE_UNCLOSED_DOUBLE_QUOTE",
+	ISSUE_TRAILING_ESCAPE:           "ISSUE_TRAILING_ESCAPE",
+	ISSUE_TRAILING_PIPE:             "ISSUE_TRAILING_PIPE",
+	ISSUE_EMPTY_COMMAND_BEFORE_PIPE: "ISSUE_EMPTY_COMMAND_BEFORE_PIPE",
+	ISSUE_UNCLOSED_SUBSTITUTION:     "ISSUE_UNCLOSED_SUBSTITUTION",
+}
+
+func (k IssueKind) MarshalJSON() ([]byte, error) {
+	return json.Marshal(issueKinds[k])
+}
+
+func (k *IssueKind) UnmarshalJSON(data []byte) error {
+	i, err := unmarshalEnum(data, func(s string) (int, bool) {
+		for kind, name := range issueKinds {
+			if name == s {
+				return int(kind), true
+			}
+		}
+		return 0, false
+	})
+	*k = IssueKind(i)
+	return err
+}
+
+func (k IssueKind) String() string {
+	if name, ok := issueKinds[k]; ok {
+		return name
+	}
+	return fmt.Sprintf("IssueKind(%d)", int(k))
+}
+
+// Issue is a problem in a command line which makes it incomplete or invalid.
+type Issue struct {
+	Kind    IssueKind
+	Index   int    // rune index of the offending quote, escape rune or delimiter
+	Message string // human readable description
+}
+
+// requiresCommand reports whether the delimiter needs a command on both sides (`|`, `|&`, `&&`, `||`).
+func requiresCommand(token Token) bool {
+	switch {
+	case token.Type != WORDBREAK_TOKEN:
+		return false
+	case token.WordbreakType.IsPipe(),
+		token.WordbreakType == WORDBREAK_LIST_AND,
+		token.WordbreakType == WORDBREAK_LIST_OR:
+		return true
+	default:
+		return false
+	}
+}
+
+// Validate returns the problems of the command line in order of their index (or an empty slice if there are none).
+// In contrast to strict mode (WithStrict) the tokens are still available, so editors can highlight the problems
+// and completion can decide whether a line is complete enough to be executed.
+func (t TokenSlice) Validate() []Issue {
+	issues := make([]Issue, 0)
+
+	empty := true // no command since the last delimiter
+	for _, token := range t {
+		switch {
+		case token.RawValue == "":
+			// empty word at the end of the input
+		case requiresCommand(token):
+			if empty {
+				issues = append(issues, Issue{
+					Kind:    ISSUE_EMPTY_COMMAND_BEFORE_PIPE,
+					Index:   token.Index,
+					Message: fmt.Sprintf("missing command before `%v`", token.RawValue),
+				})
+			}
+			empty = true
+		case token.Type == WORDBREAK_TOKEN && token.WordbreakType.IsPipelineDelimiter(),
+			token.Type == GROUP_TOKEN && (token.Value == "(" || token.Value == "{"):
+			empty = true
+		default:
+			empty = false
+		}
+	}
+
+	for index := len(t) - 1; index >= 0; index-- {
+		if token := t[index]; token.RawValue != "" {
+			if requiresCommand(token) {
+				issues = append(issues, Issue{
+					Kind:    ISSUE_TRAILING_PIPE,
+					Index:   token.Index,
+					Message: fmt.Sprintf("missing command after `%v`", token.RawValue),
+				})
+			}
+			break
+		}
+	}
+
+	if len(t) > 0 {
+		issues = append(issues, t[len(t)-1].unterminatedIssues()...)
+	}
+	return issues
+}
+
+// unterminatedIssues returns the issues of a token ended by the end of the input within quotes,
+// a substitution or after an escape rune. A substitution within double quotes leaves both unclosed.
+func (t Token) unterminatedIssues() []Issue {
+	switch t.State {
+	case QUOTING_STATE, ANSI_C_QUOTING_STATE, ESCAPING_ANSI_C_STATE:
+		return []Issue{{Kind: ISSUE_UNCLOSED_SINGLE_QUOTE, Index: t.quoteIndex(), Message: "unclosed single quote"}}
+	case QUOTING_ESCAPING_STATE, ESCAPING_QUOTED_STATE:
+		return []Issue{{Kind: ISSUE_UNCLOSED_DOUBLE_QUOTE, Index: t.quoteIndex(), Message: "unclosed double quote"}}
+	case ESCAPING_STATE:
+		return []Issue{{Kind: ISSUE_TRAILING_ESCAPE, Index: t.EndIndex - 1, Message: "trailing escape"}}
+	case SUBSTITUTION_STATE, ARITHMETIC_STATE:
+		issues := make([]Issue, 0, 2)
+		var substitutionError *UnclosedSubstitutionError
+		if !errors.As(t.strictError(), &substitutionError) {
+			return issues
+		}
+		if substitutionError.QuoteIndex >= 0 {
+			issues = append(issues, Issue{Kind: ISSUE_UNCLOSED_DOUBLE_QUOTE, Index: t.Index + substitutionError.QuoteIndex, Message: "unclosed double quote"})
+		}
+		return append(issues, Issue{Kind: ISSUE_UNCLOSED_SUBSTITUTION, Index: t.Index + substitutionError.Index, Message: "unclosed substitution"})
+	default:
+		return []Issue{}
+	}
+}
+
+// quoteIndex returns the rune index of the unclosed quote of the token.
+func (t Token) quoteIndex() int {
+	var quoteError *UnclosedQuoteError
+	if errors.As(t.strictError(), &quoteError) {
+		return t.Index + quoteError.Index
+	}
+	return t.Index
+}
+
+// strictError returns the strict mode error of the raw value of the token.
+func (t Token) strictError() error {
+	_, err := Split(t.RawValue, WithStrict(true), WithComments(false), WithGroups(false))
+	return err
+}
@@ -0,0 +1,62 @@
+package shlex
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := map[string][]Issue{
+		"":                {},
+		"git status":      {},
+		"a | b && c; d &": {},
+		"a |\nb":          {},
+		"(a) | b":         {},
+		"echo 'abc":       {{ISSUE_UNCLOSED_SINGLE_QUOTE, 5, "unclosed single quote"}},
+		"echo a'b c":      {{ISSUE_UNCLOSED_SINGLE_QUOTE, 6, "unclosed single quote"}},
+		"echo $'a\\":      {{ISSUE_UNCLOSED_SINGLE_QUOTE, 6, "unclosed single quote"}},
+		`echo "a \"b`:     {{ISSUE_UNCLOSED_DOUBLE_QUOTE, 5, "unclosed double quote"}},
+		`echo "a\`:        {{ISSUE_UNCLOSED_DOUBLE_QUOTE, 5, "unclosed double quote"}},
+		`echo a\`:         {{ISSUE_TRAILING_ESCAPE, 6, "trailing escape"}},
+		`echo $(ls`:       {{ISSUE_UNCLOSED_SUBSTITUTION, 5, "unclosed substitution"}},
+		`echo a$((1+`:     {{ISSUE_UNCLOSED_SUBSTITUTION, 6, "unclosed substitution"}},
+		`echo "a$(ls 'b`:  {{ISSUE_UNCLOSED_DOUBLE_QUOTE, 5, "unclosed double quote"}, {ISSUE_UNCLOSED_SUBSTITUTION, 7, "unclosed substitution"}},
+		"a |":             {{ISSUE_TRAILING_PIPE, 2, "missing command after `|`"}},
+		"a && ":           {{ISSUE_TRAILING_PIPE, 2, "missing command after `&&`"}},
+		"a |& ":           {{ISSUE_TRAILING_PIPE, 2, "missing command after `|&`"}},
+		"a ||":            {{ISSUE_TRAILING_PIPE, 2, "missing command after `||`"}},
+		"| a":             {{ISSUE_EMPTY_COMMAND_BEFORE_PIPE, 0, "missing command before `|`"}},
+		"a; && b":         {{ISSUE_EMPTY_COMMAND_BEFORE_PIPE, 3, "missing command before `&&`"}},
+		"(|| a)":          {{ISSUE_EMPTY_COMMAND_BEFORE_PIPE, 1, "missing command before `||`"}},
+		"a | | b":         {{ISSUE_EMPTY_COMMAND_BEFORE_PIPE, 4, "missing command before `|`"}},
+		"|":               {{ISSUE_EMPTY_COMMAND_BEFORE_PIPE, 0, "missing command before `|`"}, {ISSUE_TRAILING_PIPE, 0, "missing command after `|`"}},
+		"| a | 'b":        {{ISSUE_EMPTY_COMMAND_BEFORE_PIPE, 0, "missing command before `|`"}, {ISSUE_UNCLOSED_SINGLE_QUOTE, 6, "unclosed single quote"}},
+		"a;":              {},
+		"a &":             {},
+		"echo '|' \"&&\"": {},
+	}
+	for s, want := range tests {
+		tokens, err := Split(s)
+		if err != nil {
+			t.Error(err)
+		}
+		if got := tokens.Validate(); !reflect.DeepEqual(got, want) {
+			t.Errorf("Split(%q).Validate() -> %#v. Want: %#v", s, got, want)
+		}
+	}
+
+	if got := (TokenSlice{}).Validate(); len(got) != 0 {
+		t.Errorf("TokenSlice{}.Validate() -> %#v", got)
+	}
+
+	issue := Issue{Kind: ISSUE_TRAILING_PIPE, Index: 2, Message: "missing command after `|`"}
+	b, err := json.Marshal(issue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Issue
+	if err := json.Unmarshal(b, &decoded); err != nil || decoded != issue {
+		t.Errorf("json roundtrip of %#v -> %s -> %#v, %v", issue, b, decoded, err)
+	}
+}
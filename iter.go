@@ -0,0 +1,33 @@
+//go:build go1.23
+
+package shlex
+
+import (
+	"io"
+	"iter"
+)
+
+// All returns an iterator over the remaining tokens.
+// Iteration stops at io.EOF, any other error is yielded along with a nil token.
+func (t *Tokenizer) All() iter.Seq2[*Token, error] {
+	return all(t.Next)
+}
+
+// All returns an iterator over the remaining tokens (see Tokenizer.All).
+func (l *Lexer) All() iter.Seq2[*Token, error] {
+	return all(l.Next)
+}
+
+func all(next func() (*Token, error)) iter.Seq2[*Token, error] {
+	return func(yield func(*Token, error) bool) {
+		for {
+			token, err := next()
+			if err == io.EOF {
+				return
+			}
+			if !yield(token, err) || err != nil {
+				return
+			}
+		}
+	}
+}
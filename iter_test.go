@@ -0,0 +1,55 @@
+//go:build go1.23
+
+package shlex
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAll(t *testing.T) {
+	s := "echo a # comment\nls | wc"
+	tokens, err := Split(s)
+	if err != nil {
+		t.Error(err)
+	}
+
+	values := make([]string, 0)
+	for token, err := range NewLexer(strings.NewReader(s)).All() {
+		if err != nil {
+			t.Error(err)
+		}
+		values = append(values, token.Value)
+	}
+	if !reflect.DeepEqual(values, tokens.Strings()) {
+		t.Errorf("Lexer.All() -> %#v. Want: %#v", values, tokens.Strings())
+	}
+
+	types := make([]TokenType, 0)
+	for token := range NewTokenizer(strings.NewReader(s)).All() {
+		types = append(types, token.Type)
+		if token.Type == COMMENT_TOKEN {
+			break
+		}
+	}
+	if want := []TokenType{WORD_TOKEN, WORD_TOKEN, COMMENT_TOKEN}; !reflect.DeepEqual(types, want) {
+		t.Errorf("Tokenizer.All() -> %v. Want: %v", types, want)
+	}
+
+	count := 0
+	for token, err := range NewLexer(strings.NewReader(`echo 'a`), WithStrict(true)).All() {
+		count++
+		var unclosedQuoteError *UnclosedQuoteError
+		switch {
+		case count == 1 && (err != nil || token.Value != "echo"):
+			t.Errorf("Lexer.All() -> %#v, %v", token, err)
+		case count == 2 && (token != nil || !errors.As(err, &unclosedQuoteError)):
+			t.Errorf("Lexer.All() -> %#v, %v. Want: UnclosedQuoteError", token, err)
+		}
+	}
+	if count != 2 {
+		t.Errorf("Lexer.All() yielded %v times. Want: 2", count)
+	}
+}
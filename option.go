@@ -0,0 +1,254 @@
+package shlex
+
+// Option configures the tokenizer.
+type Option func(*config)
+
+// config contains the optional tokenizer settings.
+type config struct {
+	dialect          Dialect
+	historyExpansion bool   // mark words starting with an unquoted `!`
+	strict           bool   // return an error for unclosed quotes and trailing escapes
+	comments         bool   // treat an unquoted `#` at the start of a word as comment
+	pipelineRunes    string // runes of pipeline delimiters (`|`, `&&`, `;`, ...)
+	doubledQuotes    bool   // a doubled quote within quotes is a literal quote (`'it''s'`)
+	dollarQuotes     bool   // ANSI-C (`$'...'`) and locale-translated (`$"..."`) quoting
+	unicodeSpaces    bool   // classify unicode whitespace (`unicode.IsSpace`) as space
+	spaceTokens      bool   // return SPACE_TOKEN for runs of spaces
+	rawWords         bool   // keep quotes and escapes in the value of words
+	groups           bool   // subshells and brace groups (GROUP_TOKEN)
+	lineContinuation bool   // remove escaped newlines
+	varExpansion     func(name string) (string, bool)
+	tildeExpansion   func(user string) (string, bool)
+	keepUnknownVars  bool // keep references to unknown variables instead of expanding them to empty
+	invalidUTF8      InvalidUTF8Policy
+	maxTokenLength   int // maximum runes of a token (0 is unlimited)
+	maxTokens        int // maximum number of tokens (0 is unlimited)
+	classifier       TokenClassifier
+	ascii            *asciiClasses // ASCII rune classes of the classifier
+	runeClasses      []runeClass   // rune classes replaced in the classifier
+}
+
+// runeClass is a set of runes for a class of the classifier.
+type runeClass struct {
+	runes string
+	class RuneTokenClass
+}
+
+// defaultConfig is the config without any options.
+var defaultConfig = config{comments: true, pipelineRunes: pipelineRunes, dollarQuotes: true, groups: true, lineContinuation: true}
+
+// newConfig creates the config for given options.
+func newConfig(opts ...Option) config {
+	if len(opts) == 0 {
+		return defaultConfig // avoids the allocation of c escaping to the options
+	}
+	c := defaultConfig
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return c
+}
+
+// runes returns the runes configured for given class (or the fallback if not replaced).
+func (c config) runes(class RuneTokenClass, fallback string) string {
+	runes := fallback
+	for _, runeClass := range c.runeClasses {
+		if runeClass.class == class {
+			runes = runeClass.runes
+		}
+	}
+	return runes
+}
+
+// WithHistoryExpansion enables detection of bash history expansion (`!!`, `!$`, `!foo`, ...).
+// Words starting with an unquoted `!` followed by a non-space rune are marked as HistoryExpansion.
+func WithHistoryExpansion(enabled bool) Option {
+	return func(c *config) {
+		c.historyExpansion = enabled
+	}
+}
+
+// WithStrict enables strict mode, which returns an UnclosedQuoteError, UnclosedSubstitutionError or TrailingEscapeError
+// instead of a truncated token when the input ends within a quoted string, a substitution or after an escape rune.
+// The default is lenient as completion works on partial input.
+func WithStrict(enabled bool) Option {
+	return func(c *config) {
+		c.strict = enabled
+	}
+}
+
+// WithComments enables comment handling (default).
+// If disabled an unquoted comment rune at the start of a word is a regular word rune.
+func WithComments(enabled bool) Option {
+	return func(c *config) {
+		c.comments = enabled
+	}
+}
+
+// WithClassifier sets the classifier used for the runes (default: NewDefaultClassifier).
+// Changes to the classifier after the tokenizer was created have no effect on ASCII runes.
+func WithClassifier(classifier TokenClassifier) Option {
+	return func(c *config) {
+		c.classifier = classifier
+	}
+}
+
+// WithCommentRunes sets the runes starting a comment (default: `#`).
+// An empty string removes comment handling.
+func WithCommentRunes(runes string) Option {
+	return func(c *config) {
+		c.runeClasses = append(c.runeClasses, runeClass{runes, COMMENT_RUNE_CLASS})
+	}
+}
+
+// WithEscapeRunes sets the escape runes (default: `\`).
+// An empty string disables escaping, which keeps backslashes in Windows paths like `C:\Program Files` intact.
+// Join then quotes words without escaping.
+func WithEscapeRunes(runes string) Option {
+	return func(c *config) {
+		c.runeClasses = append(c.runeClasses, runeClass{runes, ESCAPE_RUNE_CLASS})
+	}
+}
+
+// WithEscapingQuotes sets the quote runes within which escape runes are interpreted (default: `"`).
+func WithEscapingQuotes(runes string) Option {
+	return func(c *config) {
+		c.runeClasses = append(c.runeClasses, runeClass{runes, ESCAPING_QUOTE_RUNE_CLASS})
+	}
+}
+
+// WithNonEscapingQuotes sets the quote runes within which all runes are literal (default: `'`).
+func WithNonEscapingQuotes(runes string) Option {
+	return func(c *config) {
+		c.runeClasses = append(c.runeClasses, runeClass{runes, NON_ESCAPING_QUOTE_RUNE_CLASS})
+	}
+}
+
+// WithPipelineRunes sets the runes of pipeline delimiters (default: `|&;`).
+// Wordbreaks consisting of other runes are not treated as pipeline delimiters (WORDBREAK_UNKNOWN).
+// An empty string disables pipeline splitting.
+func WithPipelineRunes(runes string) Option {
+	return func(c *config) {
+		c.pipelineRunes = runes
+	}
+}
+
+// WithDialect configures the tokenizer for given shell (default: BASH_DIALECT).
+// Options passed after it override the settings of the dialect.
+func WithDialect(d Dialect) Option {
+	return func(c *config) {
+		c.dialect = d
+		for _, opt := range d.options() {
+			opt(c)
+		}
+	}
+}
+
+// WithWordbreaks sets the wordbreak runes (default: COMP_WORDBREAKS environment variable or BASH_WORDBREAKS).
+// Runes already classified as space, quote, escape or comment are not affected.
+func WithWordbreaks(runes string) Option {
+	return func(c *config) {
+		c.runeClasses = append(c.runeClasses, runeClass{runes, WORDBREAK_RUNE_CLASS})
+	}
+}
+
+// WithUnicodeSpaces classifies unicode whitespace like U+00A0 (no-break space) as space (default: ASCII only).
+// Runes explicitly classified by the classifier are not affected.
+func WithUnicodeSpaces(enabled bool) Option {
+	return func(c *config) {
+		c.unicodeSpaces = enabled
+	}
+}
+
+// InvalidUTF8Policy determines how invalid UTF-8 in the input is handled.
+type InvalidUTF8Policy int
+
+const (
+	REPLACE_INVALID_UTF8      InvalidUTF8Policy = iota // replace each invalid byte with U+FFFD
+	ERROR_ON_INVALID_UTF8                              // return a LexError wrapping InvalidUTF8Error
+	PASS_THROUGH_INVALID_UTF8                          // keep invalid bytes as is in Value and RawValue
+)
+
+// WithInvalidUTF8 sets how invalid UTF-8 is handled (default: REPLACE_INVALID_UTF8).
+// Tokens containing invalid UTF-8 are marked with HasInvalidUTF8.
+func WithInvalidUTF8(policy InvalidUTF8Policy) Option {
+	return func(c *config) {
+		c.invalidUTF8 = policy
+	}
+}
+
+// WithSpaceTokens returns a SPACE_TOKEN for each run of spaces, which enables reconstruction of the input
+// by concatenating the raw values of all tokens. The Lexer skips these.
+func WithSpaceTokens(enabled bool) Option {
+	return func(c *config) {
+		c.spaceTokens = enabled
+	}
+}
+
+// WithRawWords keeps quotes and escape runes in the value of words (Value equals RawValue).
+// Word boundaries are still determined as usual. Expansions are not recorded in this mode.
+func WithRawWords(enabled bool) Option {
+	return func(c *config) {
+		c.rawWords = enabled
+	}
+}
+
+// WithMaxTokenLength limits the number of runes scanned for a single token and for the spaces before it (default: 0 for unlimited).
+// Exceeding it aborts with a LexError wrapping TokenTooLongError, which bounds memory for untrusted input.
+func WithMaxTokenLength(n int) Option {
+	return func(c *config) {
+		c.maxTokenLength = n
+	}
+}
+
+// WithMaxTokens limits the number of tokens scanned including comments and space tokens (default: 0 for unlimited).
+// Exceeding it aborts with a LexError wrapping TooManyTokensError.
+func WithMaxTokens(n int) Option {
+	return func(c *config) {
+		c.maxTokens = n
+	}
+}
+
+// WithGroups enables subshells and brace groups (default).
+// If disabled parentheses and braces are regular word runes.
+func WithGroups(enabled bool) Option {
+	return func(c *config) {
+		c.groups = enabled
+	}
+}
+
+// WithLineContinuation removes an escaped newline from the value (default).
+// If disabled the newline is kept like any other escaped rune.
+func WithLineContinuation(enabled bool) Option {
+	return func(c *config) {
+		c.lineContinuation = enabled
+	}
+}
+
+// WithVarExpansion substitutes variable references (`$NAME`, `${NAME}`) outside single quotes in the value
+// of a token with the value returned by given function, like the shell does before passing arguments.
+// RawValue is left untouched and the Expansion spans the substituted value.
+// Unknown variables expand to empty (see WithKeepUnknownVars). Other parameter expansions (`${NAME:-default}`)
+// as well as special parameters (`$1`, `$?`) are kept literal.
+func WithVarExpansion(f func(name string) (string, bool)) Option {
+	return func(c *config) {
+		c.varExpansion = f
+	}
+}
+
+// WithKeepUnknownVars keeps references to variables unknown to WithVarExpansion literal instead of expanding them to empty.
+func WithKeepUnknownVars(enabled bool) Option {
+	return func(c *config) {
+		c.keepUnknownVars = enabled
+	}
+}
+
+// WithTildeExpansion substitutes an unquoted tilde prefix at the start of a word (`~`, `~/x`, `~user/x`)
+// in the value of the token with the home directory returned by given function (user is empty for `~`).
+// RawValue is left untouched. A quoted or escaped tilde prefix (`"~"`, `\~`, `~"user"`) and tildes within a word
+// are kept literal, just like the prefix if the function returns false.
+func WithTildeExpansion(f func(user string) (string, bool)) Option {
+	return func(c *config) {
+		c.tildeExpansion = f
+	}
+}
@@ -0,0 +1,7 @@
+//go:build race
+// +build race
+
+package shlex
+
+// raceEnabled reports whether the race detector is enabled, which randomly drops items of a sync.Pool.
+const raceEnabled = true
@@ -2,11 +2,18 @@ package shlex
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"os"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
 )
 
 // TokenType is a top-level token classification: A word, space, comment, unknown.
@@ -16,8 +23,47 @@ func (t TokenType) MarshalJSON() ([]byte, error) {
 	return json.Marshal(tokenTypes[t])
 }
 
-// runeTokenClass is the type of a UTF-8 character classification: A quote, space, escape.
-type runeTokenClass int
+func (t *TokenType) UnmarshalJSON(data []byte) error {
+	i, err := unmarshalEnum(data, func(s string) (int, bool) {
+		for tokenType, name := range tokenTypes {
+			if name == s {
+				return int(tokenType), true
+			}
+		}
+		return 0, false
+	})
+	*t = TokenType(i)
+	return err
+}
+
+func (t TokenType) String() string {
+	if name, ok := tokenTypes[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("TokenType(%d)", int(t))
+}
+
+// RuneTokenClass is the type of a UTF-8 character classification: A quote, space, escape.
+type RuneTokenClass int
+
+var runeTokenClasses = map[RuneTokenClass]string{
+	UNKNOWN_RUNE_CLASS:            "UNKNOWN_RUNE_CLASS",
+	SPACE_RUNE_CLASS:              "SPACE_RUNE_CLASS",
+	ESCAPING_QUOTE_RUNE_CLASS:     "ESCAPING_QUOTE_RUNE_CLASS",
+	NON_ESCAPING_QUOTE_RUNE_CLASS: "NON_ESCAPING_QUOTE_RUNE_CLASS",
+	ESCAPE_RUNE_CLASS:             "ESCAPE_RUNE_CLASS",
+	COMMENT_RUNE_CLASS:            "COMMENT_RUNE_CLASS",
+	DOLLAR_RUNE_CLASS:             "DOLLAR_RUNE_CLASS",
+	BACKQUOTE_RUNE_CLASS:          "BACKQUOTE_RUNE_CLASS",
+	WORDBREAK_RUNE_CLASS:          "WORDBREAK_RUNE_CLASS",
+}
+
+func (c RuneTokenClass) String() string {
+	if name, ok := runeTokenClasses[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("RuneTokenClass(%d)", int(c))
+}
 
 // the internal state used by the lexer state machine
 type LexerState int
@@ -26,28 +72,269 @@ func (l LexerState) MarshalJSON() ([]byte, error) {
 	return json.Marshal(lexerStates[l])
 }
 
+func (l *LexerState) UnmarshalJSON(data []byte) error {
+	i, err := unmarshalEnum(data, func(s string) (int, bool) {
+		for state, name := range lexerStates {
+			if name == s {
+				return int(state), true
+			}
+		}
+		return 0, false
+	})
+	*l = LexerState(i)
+	return err
+}
+
+func (l LexerState) String() string {
+	if name, ok := lexerStates[l]; ok {
+		return name
+	}
+	return fmt.Sprintf("LexerState(%d)", int(l))
+}
+
+// unmarshalEnum decodes an enum value given either by name (using lookup) or numeric value.
+func unmarshalEnum(data []byte, lookup func(string) (int, bool)) (int, error) {
+	var i int
+	if err := json.Unmarshal(data, &i); err == nil {
+		return i, nil
+	}
+
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return 0, err
+	}
+	if i, ok := lookup(s); ok {
+		return i, nil
+	}
+	return 0, fmt.Errorf("unknown value: %v", s)
+}
+
 // Token is a (type, value) pair representing a lexographical token.
 type Token struct {
-	Type           TokenType
-	Value          string
-	RawValue       string
-	Index          int
-	State          LexerState
-	WordbreakType  WordbreakType `json:",omitempty"`
-	WordbreakIndex int           // index of last opening quote in Value (only correct when in quoting state)
+	Type             TokenType
+	Value            string
+	RawValue         string
+	Index            int
+	EndIndex         int    // index after the last rune of RawValue
+	ByteIndex        int    // byte offset of Index in the input
+	ByteEndIndex     int    // byte offset of EndIndex in the input
+	Leading          string `json:",omitempty"` // skipped spaces (and comments when using the Lexer) before the token
+	Quote            string `json:",omitempty"` // opening quote of the last quoted section (`'`, `"`, `$'`, `$"` or "`")
+	Unterminated     bool   `json:",omitempty"` // whether EOF was reached within a quoting or escaping state
+	State            LexerState
+	WordbreakType    WordbreakType `json:",omitempty"`
+	WordbreakIndex   int           // index of last opening quote in Value (only correct when in quoting state)
+	HasSubstitution  bool          `json:",omitempty"` // whether Value contains a command substitution
+	Expansions       []Expansion   `json:",omitempty"` // variable references in Value
+	RawOffsets       []int         `json:"-"`          // rune index in RawValue at which each rune of Value begins
+	TildeExpandable  bool          `json:",omitempty"` // whether Value starts with an unquoted tilde (see WithTildeExpansion)
+	HasGlob          bool          `json:",omitempty"` // whether Value contains an unquoted glob metacharacter
+	HistoryExpansion bool          `json:",omitempty"` // whether Value starts with an unquoted `!` (see WithHistoryExpansion)
+	HasInvalidUTF8   bool          `json:",omitempty"` // whether RawValue contains invalid UTF-8 (see WithInvalidUTF8)
+
+	rawLength int    // rune count of RawValue
+	value     []byte // Value while scanning
+	raw       []byte // RawValue while scanning
+	closing   string // runes closing an unterminated substitution (see ClosingSuffix)
+}
+
+// Expansion is a variable reference ($VAR or ${VAR}) within the value of a token.
+type Expansion struct {
+	Name  string
+	Start int // index of the dollar rune in Value (or of the substituted value, see WithVarExpansion)
+	End   int // index after the reference in Value
 }
 
 func (t *Token) add(r rune) {
-	t.Value += string(r)
+	t.value = appendRune(t.value, r)
+	t.RawOffsets = append(t.RawOffsets, t.rawLength-1)
+}
+
+// addEscaped adds a rune to the value which was escaped by the previous raw rune.
+func (t *Token) addEscaped(r rune) {
+	t.value = appendRune(t.value, r)
+	t.RawOffsets = append(t.RawOffsets, t.rawLength-2)
+}
+
+func (t *Token) addRaw(r rune) {
+	if r > utf8.MaxRune {
+		t.HasInvalidUTF8 = true
+	}
+	t.raw = appendRune(t.raw, r)
+	t.rawLength += 1
 }
 
 func (t *Token) removeLastRaw() {
-	runes := []rune(t.RawValue)
-	t.RawValue = string(runes[:len(runes)-1])
+	_, size := utf8.DecodeLastRune(t.raw)
+	t.raw = t.raw[:len(t.raw)-size]
+	t.rawLength -= 1
+}
+
+// materialize sets Value and RawValue from the buffers used while scanning.
+// Given previous strings are reused if equal.
+func (t *Token) materialize(value, rawValue string) {
+	if t.Value = value; string(t.value) != value {
+		t.Value = string(t.value)
+	}
+	if t.RawValue = rawValue; string(t.raw) != rawValue {
+		t.RawValue = string(t.raw)
+	}
+	t.value, t.raw = nil, nil
+}
+
+// asciiRunes contains all ASCII runes in order.
+var asciiRunes = func() string {
+	runes := make([]byte, utf8.RuneSelf)
+	for index := range runes {
+		runes[index] = byte(index)
+	}
+	return string(runes)
+}()
+
+// runeString is like string(r) but doesn't allocate for ASCII runes.
+func runeString(r rune) string {
+	if r >= 0 && r < utf8.RuneSelf {
+		return asciiRunes[r : r+1]
+	}
+	return string(r)
+}
+
+// byteOrderMark is skipped at the start of the input (see Tokenizer).
+const byteOrderMark = '\uFEFF'
+
+// Invalid UTF-8 is read as runes beyond utf8.MaxRune (see Tokenizer.readRune).
+const (
+	replacedRune     = utf8.MaxRune + 1 // invalid byte replaced by U+FFFD
+	invalidByteRunes = utf8.MaxRune + 2 // invalidByteRunes+b is the invalid byte b passed through
+)
+
+// appendRune appends the UTF-8 encoding of given rune.
+// Passed through invalid bytes are appended as is.
+func appendRune(b []byte, r rune) []byte {
+	if r >= invalidByteRunes {
+		return append(b, byte(r-invalidByteRunes))
+	}
+	var encoded [utf8.UTFMax]byte
+	return append(b, encoded[:utf8.EncodeRune(encoded[:], r)]...)
+}
+
+// ValueOffset returns the index of the rune in Value corresponding to given rune index in RawValue.
+// Quotes and escape runes map to the next rune in Value.
+func (t Token) ValueOffset(rawIndex int) int {
+	for index, offset := range t.RawOffsets {
+		if offset >= rawIndex {
+			return index
+		}
+	}
+	return len(t.RawOffsets)
+}
+
+// RawOffset returns the index of the rune in RawValue at which given rune index in Value begins.
+func (t Token) RawOffset(valueIndex int) int {
+	if valueIndex >= 0 && valueIndex < len(t.RawOffsets) {
+		return t.RawOffsets[valueIndex]
+	}
+	return utf8.RuneCountInString(t.RawValue)
+}
+
+// SplitAssign splits a word at its first unquoted `=` (`--output=json`, `FOO=bar`, `key='long value'`).
+// A quoted or escaped `=` (`'a=b'`, `a\=b`) does not separate key and value. A leading `=` results in an empty key.
+// Index is the rune index in the input at which the value starts, so completion can replace just the value.
+func (t Token) SplitAssign() (key string, value string, index int, ok bool) {
+	if t.Type != WORD_TOKEN && t.Type != WORDBREAK_TOKEN { // words starting with `=` are WORDBREAK_TOKEN (see Words)
+		return "", "", 0, false
+	}
+	tokens, err := Split(t.RawValue, WithWordbreaks("="), WithComments(false), WithGroups(false))
+	if err != nil {
+		return "", "", 0, false
+	}
+	for _, token := range tokens {
+		if token.Type == WORDBREAK_TOKEN {
+			runes := []rune(t.Value)
+			separator := t.ValueOffset(token.Index)
+			return string(runes[:separator]), string(runes[separator+1:]), t.Index + token.Index + 1, true
+		}
+	}
+	return "", "", 0, false
+}
+
+// requoteRunes are the runes escaped by Requote in unquoted words.
+const requoteRunes = " \t\r\n\\'\"`$|&;<>()*?[]{}#~=:%!"
+
+// joinRunes are the runes which need quoting in Join.
+// Wordbreaks like `=` and `:` as well as `~` are left as is since these don't separate words (see TokenSlice.Words).
+const joinRunes = " \t\r\n\\'\"`$|&;<>()*?[]{}#%!"
+
+// Requote returns given value quoted in the same style as the token (see Quote).
+// Unquoted tokens are escaped with backslashes.
+func (t Token) Requote(value string) string {
+	switch t.Quote {
+	case "'":
+		return "'" + strings.Replace(value, "'", `'\''`, -1) + "'"
+	case "$'":
+		return "$'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(value) + "'"
+	case `"`, `$"`, "`":
+		return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`, "`", "\\`").Replace(value) + `"`
+	default:
+		if value == "" {
+			return "''"
+		}
+		var requoted strings.Builder
+		for _, r := range value {
+			if strings.ContainsRune(requoteRunes, r) {
+				requoted.WriteRune('\\')
+			}
+			requoted.WriteRune(r)
+		}
+		return requoted.String()
+	}
+}
+
+// ClosingSuffix returns the runes to append to make the token well-formed, like the closing quote
+// for an unterminated quoted string (`"a 'b` -> `"`). A trailing escape rune is escaped itself.
+// It is empty for a regular word.
+func (t Token) ClosingSuffix() string {
+	switch t.State {
+	case QUOTING_ESCAPING_STATE:
+		return `"`
+	case ESCAPING_QUOTED_STATE:
+		return `\"`
+	case QUOTING_STATE, ANSI_C_QUOTING_STATE:
+		return "'"
+	case ESCAPING_ANSI_C_STATE:
+		return `\'`
+	case ESCAPING_STATE:
+		return `\`
+	case BACKQUOTING_STATE:
+		return "`"
+	case ESCAPING_BACKQUOTED_STATE:
+		return "\\`"
+	case SUBSTITUTION_STATE, ARITHMETIC_STATE:
+		return t.closing
+	default:
+		return ""
+	}
+}
+
+func (t Token) isRedirect() bool {
+	return t.Type == WORDBREAK_TOKEN && t.WordbreakType.IsRedirect()
+}
+
+// isFileDescriptor checks whether the (unquoted) word consists only of digits and is thus a
+// file descriptor when directly followed by given redirect rune.
+func (t Token) isFileDescriptor(r rune) bool {
+	value := string(t.value)
+	return value != "" && strings.Trim(value, digitRunes) == "" && string(t.raw) == value+string(r)
+}
+
+// redirectOperator returns the raw value without a leading file descriptor (`2>&` -> `>&`).
+func (t Token) redirectOperator() string {
+	return strings.TrimLeft(t.RawValue, digitRunes)
 }
 
-func (t Token) adjoins(other Token) bool {
-	return t.Index+len(t.RawValue) == other.Index || t.Index == other.Index+len(other.RawValue)
+// Adjoins reports whether the tokens are directly adjacent (not separated by spaces or comments).
+func (t *Token) Adjoins(other *Token) bool {
+	return t.EndIndex == other.Index || t.Index == other.EndIndex
 }
 
 // Equal reports whether tokens a, and b, are equal.
@@ -61,15 +348,39 @@ func (t *Token) Equal(other *Token) bool {
 		t.Value != other.Value,
 		t.RawValue != other.RawValue,
 		t.Index != other.Index,
+		t.EndIndex != other.EndIndex,
+		t.ByteIndex != other.ByteIndex,
+		t.ByteEndIndex != other.ByteEndIndex,
+		t.Leading != other.Leading,
+		t.Quote != other.Quote,
+		t.Unterminated != other.Unterminated,
 		t.State != other.State,
 		t.WordbreakType != other.WordbreakType,
-		t.WordbreakIndex != other.WordbreakIndex:
+		t.WordbreakIndex != other.WordbreakIndex,
+		t.HasSubstitution != other.HasSubstitution,
+		t.TildeExpandable != other.TildeExpandable,
+		t.HasGlob != other.HasGlob,
+		t.HistoryExpansion != other.HistoryExpansion,
+		t.HasInvalidUTF8 != other.HasInvalidUTF8,
+		!equalExpansions(t.Expansions, other.Expansions):
 		return false
 	default:
 		return true
 	}
 }
 
+func equalExpansions(a, b []Expansion) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for index := range a {
+		if a[index] != b[index] {
+			return false
+		}
+	}
+	return true
+}
+
 // Named classes of UTF-8 runes
 const (
 	spaceRunes            = " \t\r\n"
@@ -77,18 +388,25 @@ const (
 	nonEscapingQuoteRunes = "'"
 	escapeRunes           = `\`
 	commentRunes          = "#"
+	dollarRunes           = "$"
+	backquoteRunes        = "`"
+	globRunes             = "*?["
+	digitRunes            = "0123456789"
+	pipelineRunes         = "|&;"
 )
 
 // Classes of rune token
 const (
-	unknownRuneClass runeTokenClass = iota
-	spaceRuneClass
-	escapingQuoteRuneClass
-	nonEscapingQuoteRuneClass
-	escapeRuneClass
-	commentRuneClass
-	wordbreakRuneClass
-	eofRuneClass
+	UNKNOWN_RUNE_CLASS            RuneTokenClass = iota // regular word rune
+	SPACE_RUNE_CLASS                                    // separates words
+	ESCAPING_QUOTE_RUNE_CLASS                           // quote within which escape runes work (`"`)
+	NON_ESCAPING_QUOTE_RUNE_CLASS                       // quote within which escape runes are literal (`'`)
+	ESCAPE_RUNE_CLASS                                   // escapes the next rune (`\`)
+	COMMENT_RUNE_CLASS                                  // starts a comment at the beginning of a word (`#`)
+	DOLLAR_RUNE_CLASS                                   // starts an expansion or substitution (`$`)
+	BACKQUOTE_RUNE_CLASS                                // starts a command substitution ("`")
+	WORDBREAK_RUNE_CLASS                                // splits words like COMP_WORDBREAKS (`=`, `:`, `|`, ...)
+	eofRuneClass                                        // end of the input (not assigned to runes)
 )
 
 // Classes of lexographic token
@@ -98,6 +416,8 @@ const (
 	SPACE_TOKEN
 	COMMENT_TOKEN
 	WORDBREAK_TOKEN
+	HEREDOC_TOKEN
+	GROUP_TOKEN
 )
 
 var tokenTypes = map[TokenType]string{
@@ -106,142 +426,511 @@ var tokenTypes = map[TokenType]string{
 	SPACE_TOKEN:     "SPACE_TOKEN",
 	COMMENT_TOKEN:   "COMMENT_TOKEN",
 	WORDBREAK_TOKEN: "WORDBREAK_TOKEN",
+	HEREDOC_TOKEN:   "HEREDOC_TOKEN",
+	GROUP_TOKEN:     "GROUP_TOKEN",
 }
 
 // Lexer state machine states
 const (
-	START_STATE            LexerState = iota // no runes have been seen
-	IN_WORD_STATE                            // processing regular runes in a word
-	ESCAPING_STATE                           // we have just consumed an escape rune; the next rune is literal
-	ESCAPING_QUOTED_STATE                    // we have just consumed an escape rune within a quoted string
-	QUOTING_ESCAPING_STATE                   // we are within a quoted string that supports escaping ("...")
-	QUOTING_STATE                            // we are within a string that does not support escaping ('...')
-	COMMENT_STATE                            // we are within a comment (everything following an unquoted or unescaped #
-	WORDBREAK_STATE                          // we have just consumed a wordbreak rune
+	START_STATE               LexerState = iota // no runes have been seen
+	IN_WORD_STATE                               // processing regular runes in a word
+	ESCAPING_STATE                              // we have just consumed an escape rune; the next rune is literal
+	ESCAPING_QUOTED_STATE                       // we have just consumed an escape rune within a quoted string
+	QUOTING_ESCAPING_STATE                      // we are within a quoted string that supports escaping ("...")
+	QUOTING_STATE                               // we are within a string that does not support escaping ('...')
+	COMMENT_STATE                               // we are within a comment (everything following an unquoted or unescaped #
+	WORDBREAK_STATE                             // we have just consumed a wordbreak rune
+	HEREDOC_STATE                               // we are within the body of a here-document
+	ANSI_C_QUOTING_STATE                        // we are within an ANSI-C quoted string ($'...')
+	ESCAPING_ANSI_C_STATE                       // we have just consumed an escape rune within an ANSI-C quoted string
+	SUBSTITUTION_STATE                          // we are within a command substitution ($(...))
+	ARITHMETIC_STATE                            // we are within an arithmetic expansion ($((...)))
+	BACKQUOTING_STATE                           // we are within a command substitution using backquotes (`...`)
+	ESCAPING_BACKQUOTED_STATE                   // we have just consumed an escape rune within backquotes
+	GROUP_STATE                                 // we have just consumed a rune opening or closing a subshell or brace group
+	SPACE_STATE                                 // we are within a run of spaces (see WithSpaceTokens)
+	PIPELINE_STATE                              // the input ends after a pipeline delimiter (only returned by TokenSlice.EndState)
 )
 
 var lexerStates = map[LexerState]string{
-	START_STATE:            "START_STATE",
-	IN_WORD_STATE:          "IN_WORD_STATE",
-	ESCAPING_STATE:         "ESCAPING_STATE",
-	ESCAPING_QUOTED_STATE:  "ESCAPING_QUOTED_STATE",
-	QUOTING_ESCAPING_STATE: "QUOTING_ESCAPING_STATE",
-	QUOTING_STATE:          "QUOTING_STATE",
-	COMMENT_STATE:          "COMMENT_STATE",
-	WORDBREAK_STATE:        "WORDBREAK_STATE",
+	START_STATE:               "START_STATE",
+	IN_WORD_STATE:             "IN_WORD_STATE",
+	ESCAPING_STATE:            "ESCAPING_STATE",
+	ESCAPING_QUOTED_STATE:     "ESCAPING_QUOTED_STATE",
+	QUOTING_ESCAPING_STATE:    "QUOTING_ESCAPING_STATE",
+	QUOTING_STATE:             "QUOTING_STATE",
+	COMMENT_STATE:             "COMMENT_STATE",
+	WORDBREAK_STATE:           "WORDBREAK_STATE",
+	HEREDOC_STATE:             "HEREDOC_STATE",
+	ANSI_C_QUOTING_STATE:      "ANSI_C_QUOTING_STATE",
+	ESCAPING_ANSI_C_STATE:     "ESCAPING_ANSI_C_STATE",
+	SUBSTITUTION_STATE:        "SUBSTITUTION_STATE",
+	ARITHMETIC_STATE:          "ARITHMETIC_STATE",
+	BACKQUOTING_STATE:         "BACKQUOTING_STATE",
+	ESCAPING_BACKQUOTED_STATE: "ESCAPING_BACKQUOTED_STATE",
+	GROUP_STATE:               "GROUP_STATE",
+	SPACE_STATE:               "SPACE_STATE",
+	PIPELINE_STATE:            "PIPELINE_STATE",
 }
 
-// tokenClassifier is used for classifying rune characters.
-type tokenClassifier map[rune]runeTokenClass
+// TokenClassifier is used for classifying rune characters.
+// Runes missing in the map are of UNKNOWN_RUNE_CLASS (see NewDefaultClassifier and WithClassifier).
+type TokenClassifier map[rune]RuneTokenClass
 
-func (typeMap tokenClassifier) addRuneClass(runes string, tokenType runeTokenClass) {
+func (typeMap TokenClassifier) addRuneClass(runes string, tokenType RuneTokenClass) {
 	for _, runeChar := range runes {
 		typeMap[runeChar] = tokenType
 	}
 }
 
-// newDefaultClassifier creates a new classifier for ASCII characters.
-func newDefaultClassifier() tokenClassifier {
-	t := tokenClassifier{}
-	t.addRuneClass(spaceRunes, spaceRuneClass)
-	t.addRuneClass(escapingQuoteRunes, escapingQuoteRuneClass)
-	t.addRuneClass(nonEscapingQuoteRunes, nonEscapingQuoteRuneClass)
-	t.addRuneClass(escapeRunes, escapeRuneClass)
-	t.addRuneClass(commentRunes, commentRuneClass)
+// setRuneClass replaces the runes of given class.
+// Wordbreak runes don't replace runes of other classes (spaces and quotes are contained in COMP_WORDBREAKS as well).
+func (typeMap TokenClassifier) setRuneClass(runes string, tokenType RuneTokenClass) {
+	for runeChar, class := range typeMap {
+		if class == tokenType {
+			delete(typeMap, runeChar)
+		}
+	}
 
-	wordbreakRunes := BASH_WORDBREAKS
-	if wordbreaks := os.Getenv("COMP_WORDBREAKS"); wordbreaks != "" {
-		wordbreakRunes = wordbreaks
+	if tokenType == WORDBREAK_RUNE_CLASS {
+		filtered := make([]rune, 0)
+		for _, r := range runes {
+			if typeMap.ClassifyRune(r) == UNKNOWN_RUNE_CLASS {
+				filtered = append(filtered, r)
+			}
+		}
+		runes = string(filtered)
 	}
-	filtered := make([]rune, 0)
-	for _, r := range wordbreakRunes {
-		if t.ClassifyRune(r) == unknownRuneClass {
-			filtered = append(filtered, r)
+	typeMap.addRuneClass(runes, tokenType)
+}
+
+// asciiClasses is a lookup table of the rune classes for ASCII runes.
+type asciiClasses [utf8.RuneSelf]RuneTokenClass
+
+// asciiClasses returns the lookup table for the ASCII runes of the classifier.
+func (typeMap TokenClassifier) asciiClasses() *asciiClasses {
+	classes := &asciiClasses{}
+	for runeChar, class := range typeMap {
+		if runeChar >= 0 && runeChar < utf8.RuneSelf {
+			classes[runeChar] = class
 		}
 	}
-	t.addRuneClass(string(filtered), wordbreakRuneClass)
+	return classes
+}
+
+func (typeMap TokenClassifier) clone() TokenClassifier {
+	c := make(TokenClassifier, len(typeMap))
+	for runeChar, class := range typeMap {
+		c[runeChar] = class
+	}
+	return c
+}
+
+// NewDefaultClassifier creates a new classifier for ASCII characters.
+func NewDefaultClassifier() TokenClassifier {
+	return newDefaultClassifier(wordbreaks())
+}
+
+// defaultClassifiers caches the default classifier (and its ASCII lookup table) by wordbreaks.
+// These are shared between tokenizers and must not be modified.
+var defaultClassifiers sync.Map
+
+type sharedClassifier struct {
+	classifier TokenClassifier
+	ascii      *asciiClasses
+}
+
+func sharedDefaultClassifier() (TokenClassifier, *asciiClasses) {
+	breaks := wordbreaks()
+	if shared, ok := defaultClassifiers.Load(breaks); ok {
+		return shared.(sharedClassifier).classifier, shared.(sharedClassifier).ascii
+	}
+	classifier := newDefaultClassifier(breaks)
+	ascii := classifier.asciiClasses()
+	defaultClassifiers.Store(breaks, sharedClassifier{classifier, ascii})
+	return classifier, ascii
+}
 
+func newDefaultClassifier(breaks string) TokenClassifier {
+	t := TokenClassifier{}
+	t.addRuneClass(spaceRunes, SPACE_RUNE_CLASS)
+	t.addRuneClass(escapingQuoteRunes, ESCAPING_QUOTE_RUNE_CLASS)
+	t.addRuneClass(nonEscapingQuoteRunes, NON_ESCAPING_QUOTE_RUNE_CLASS)
+	t.addRuneClass(escapeRunes, ESCAPE_RUNE_CLASS)
+	t.addRuneClass(commentRunes, COMMENT_RUNE_CLASS)
+	t.addRuneClass(dollarRunes, DOLLAR_RUNE_CLASS)
+	t.addRuneClass(backquoteRunes, BACKQUOTE_RUNE_CLASS)
+
+	t.setRuneClass(breaks, WORDBREAK_RUNE_CLASS)
 	return t
 }
 
 // ClassifyRune classifiees a rune
-func (t tokenClassifier) ClassifyRune(runeVal rune) runeTokenClass {
+func (t TokenClassifier) ClassifyRune(runeVal rune) RuneTokenClass {
 	return t[runeVal]
 }
 
-// lexer turns an input stream into a sequence of tokens. Whitespace and comments are skipped.
-type lexer tokenizer
+// Lexer turns an input stream into a sequence of tokens. Whitespace and comments are skipped.
+type Lexer Tokenizer
+
+// NewLexer creates a new lexer from an input stream.
+func NewLexer(r io.Reader, opts ...Option) *Lexer {
+	return (*Lexer)(NewTokenizer(r, opts...))
+}
 
-// newLexer creates a new lexer from an input stream.
-func newLexer(r io.Reader) *lexer {
-	return (*lexer)(newTokenizer(r))
+// NewLexerString creates a new lexer reading directly from a string (see NewTokenizerString).
+func NewLexerString(s string, opts ...Option) *Lexer {
+	return (*Lexer)(NewTokenizerString(s, opts...))
 }
 
 // Next returns the next token, or an error. If there are no more tokens,
 // the error will be io.EOF.
-func (l *lexer) Next() (*Token, error) {
+func (l *Lexer) Next() (*Token, error) {
+	token := &Token{}
+	if err := skipTokens(token, (*Tokenizer)(l).nextInto); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// skipTokens scans the next token into given token skipping comments and spaces, which are added to its leading text.
+// Any other token type is passed through.
+func skipTokens(token *Token, next func(*Token) error) error {
+	skipped := ""
 	for {
-		token, err := (*tokenizer)(l).Next()
-		if err != nil {
-			return token, err
+		if err := next(token); err != nil {
+			return err
 		}
 		switch token.Type {
-		case WORD_TOKEN, WORDBREAK_TOKEN:
-			return token, nil
-		case COMMENT_TOKEN:
-			// skip comments
+		case COMMENT_TOKEN, SPACE_TOKEN:
+			skipped += token.Leading + token.RawValue
 		default:
-			return nil, fmt.Errorf("unknown token type: %v", token.Type)
+			token.Leading = skipped + token.Leading
+			return nil
 		}
 	}
 }
 
-// tokenizer turns an input stream into a sequence of typed tokens
-type tokenizer struct {
-	input      bufio.Reader
-	classifier tokenClassifier
-	index      int
-	state      LexerState
+// heredoc is a here-document whose body starts after the next newline.
+type heredoc struct {
+	delimiter string
+	stripTabs bool // `<<-` strips leading tabs from body lines and the delimiter line
+}
+
+// Tokenizer turns an input stream into a sequence of typed tokens
+//
+// A UTF-8 byte order mark (U+FEFF) at the start of the input is added to the Leading of the first token
+// instead of its value. Indexes still count it, so they match offsets in the original input.
+// A byte order mark anywhere else is a literal rune.
+type Tokenizer struct {
+	config
+	input             runeInput
+	index             int
+	state             LexerState
+	heredoc           *heredoc  // here-document operator awaiting its delimiter word
+	heredocs          []heredoc // here-documents awaiting their body
+	argument          bool      // whether the next word is an argument (not in command position)
+	subshells         int       // number of open subshells
+	quote             rune      // last opening quote
+	quoteIndex        int       // index of the last opening quote
+	substitutionIndex int       // index of the last opened substitution (see scanSubstitution)
+	byteIndex         int       // byte offset of the next rune
+	lastSize          int       // size of the last rune read
+	tokenStart        int       // index at which scanning of the current token started (see WithMaxTokenLength)
+	tokens            int       // number of tokens scanned (see WithMaxTokens)
+
+	heredocBody    bool // the newline starting a here-document body was returned as space token
+	commentNewline int  // number of runes of the newline ending the last comment (left for the next token)
+	passedThrough  bool // the last rune read is a passed through invalid byte (see WithInvalidUTF8)
+
+	pushedBack []*Token        // tokens returned by Next before scanning further (see PushBack)
+	pending    chan scanResult // token still being scanned for an abandoned NextContext call
+	value      []byte          // reused buffer for the value of the next token
+	raw        []byte          // reused buffer for the raw value of the next token
+}
+
+// ReadRune reads the next rune of the input.
+// Invalid UTF-8 is returned as utf8.RuneError (or an error) depending on WithInvalidUTF8.
+func (t *Tokenizer) ReadRune() (r rune, size int, err error) {
+	if r, size, err = t.readRune(); r > utf8.MaxRune {
+		r = utf8.RuneError
+	}
+	return
 }
 
-func (t *tokenizer) ReadRune() (r rune, size int, err error) {
-	if r, size, err = t.input.ReadRune(); err == nil {
-		t.index += 1
+// readRune is like ReadRune, but returns invalid UTF-8 as a rune beyond utf8.MaxRune
+// so it can be told apart from a literal U+FFFD.
+func (t *Tokenizer) readRune() (r rune, size int, err error) {
+	t.passedThrough = false
+	if r, size, err = t.input.ReadRune(); err != nil {
+		return
+	}
+	if r == utf8.RuneError && size == 1 {
+		switch t.invalidUTF8 {
+		case ERROR_ON_INVALID_UTF8:
+			t.input.UnreadRune() // keep the invalid byte so the error is returned again
+			return utf8.RuneError, 0, &InvalidUTF8Error{Offset: t.byteIndex}
+		case PASS_THROUGH_INVALID_UTF8:
+			t.input.UnreadRune()
+			b, _ := t.input.ReadByte()
+			r = invalidByteRunes + rune(b)
+			t.passedThrough = true
+		default:
+			r = replacedRune
+		}
 	}
+	t.index += 1
+	t.byteIndex += size
+	t.lastSize = size
 	return
 }
 
-func (t *tokenizer) UnreadRune() (err error) {
-	if err = t.input.UnreadRune(); err == nil {
+func (t *Tokenizer) UnreadRune() (err error) {
+	if t.passedThrough {
+		err = t.input.UnreadByte() // the invalid byte was read with ReadByte
+		t.passedThrough = false
+	} else {
+		err = t.input.UnreadRune()
+	}
+	if err == nil {
 		t.index -= 1
+		t.byteIndex -= t.lastSize
 	}
 	return
 }
 
-// newTokenizer creates a new tokenizer from an input stream.
-func newTokenizer(r io.Reader) *tokenizer {
-	input := bufio.NewReader(r)
-	classifier := newDefaultClassifier()
-	return &tokenizer{
-		input:      *input,
-		classifier: classifier}
+// openQuote registers an opening quote (which was just read) for the token and strict mode errors.
+func (t *Tokenizer) openQuote(token *Token, quote rune) {
+	token.Quote = runeString(quote)
+	t.quote = quote
+	t.quoteIndex = t.index - 1
+}
+
+// unterminated marks the token as ended by EOF within a quoting, escaping or substitution state
+// and returns the strict mode error for it.
+func (t *Tokenizer) unterminated(token *Token) error {
+	token.Unterminated = true
+	if !t.strict {
+		return nil
+	}
+	switch t.state {
+	case ESCAPING_STATE:
+		return &LexError{Index: t.index - 1, State: t.state, Msg: "trailing escape", Err: &TrailingEscapeError{Index: t.index - 1}}
+	case SUBSTITUTION_STATE, ARITHMETIC_STATE:
+		quoteIndex := -1
+		if strings.HasSuffix(token.closing, `"`) { // closing of a substitution within double quotes ends with the double quote
+			quoteIndex = t.quoteIndex
+		}
+		return &LexError{Index: t.substitutionIndex, State: t.state, Msg: "unclosed substitution", Err: &UnclosedSubstitutionError{Index: t.substitutionIndex, QuoteIndex: quoteIndex}}
+	}
+	return &LexError{Index: t.quoteIndex, State: t.state, Msg: "unclosed quote", Err: &UnclosedQuoteError{Index: t.quoteIndex, Quote: t.quote}}
+}
+
+// classifyRune classifies a rune using the classifier (and unicode spaces if enabled).
+// ASCII runes are looked up in a table, which is considerably faster than the map lookup.
+func (t *Tokenizer) classifyRune(r rune) RuneTokenClass {
+	var class RuneTokenClass
+	if r >= 0 && r < utf8.RuneSelf {
+		class = t.ascii[r]
+	} else {
+		class = t.classifier.ClassifyRune(r)
+	}
+	if class == UNKNOWN_RUNE_CLASS && t.unicodeSpaces && unicode.IsSpace(r) {
+		return SPACE_RUNE_CLASS
+	}
+	return class
+}
+
+// peekRune returns the next rune without consuming it.
+func (t *Tokenizer) peekRune() (rune, error) {
+	r, _, err := t.readRune()
+	if err == nil {
+		err = t.UnreadRune()
+	}
+	return r, err
+}
+
+// consumeRune reads the next rune as part of the raw value of the token.
+func (t *Tokenizer) consumeRune(token *Token) (rune, error) {
+	if err := t.tooLong(token); err != nil {
+		return 0, err // stops scanning of substitutions and escape sequences
+	}
+	r, _, err := t.readRune()
+	if err == nil {
+		token.addRaw(r)
+	}
+	return r, err
+}
+
+// NewTokenizer creates a new tokenizer from an input stream.
+func NewTokenizer(r io.Reader, opts ...Option) *Tokenizer {
+	return &Tokenizer{
+		config: newTokenizerConfig(opts...),
+		input:  readerInput{bufio.NewReader(r)}}
+}
+
+// NewTokenizerString creates a new tokenizer reading directly from a string (without buffering).
+func NewTokenizerString(s string, opts ...Option) *Tokenizer {
+	return &Tokenizer{
+		config: newTokenizerConfig(opts...),
+		input:  &stringInput{s: s}}
+}
+
+// runeInput is the input of a tokenizer.
+type runeInput interface {
+	io.RuneScanner
+	io.ByteScanner
+	startsWith(prefix string) bool // whether the unread input starts with prefix
+}
+
+// readerInput buffers an input stream.
+type readerInput struct {
+	*bufio.Reader
+}
+
+func (r readerInput) startsWith(prefix string) bool {
+	b, _ := r.Peek(len(prefix))
+	return string(b) == prefix
+}
+
+// stringInput reads directly from a string.
+type stringInput struct {
+	s        string
+	offset   int // byte offset of the next rune
+	lastSize int // size of the last rune read (0 if it can't be unread)
+}
+
+func (s *stringInput) ReadRune() (rune, int, error) {
+	if s.offset >= len(s.s) {
+		s.lastSize = 0
+		return 0, 0, io.EOF
+	}
+	r, size := utf8.DecodeRuneInString(s.s[s.offset:])
+	s.offset += size
+	s.lastSize = size
+	return r, size, nil
+}
+
+func (s *stringInput) UnreadRune() error {
+	if s.lastSize == 0 {
+		return bufio.ErrInvalidUnreadRune
+	}
+	s.offset -= s.lastSize
+	s.lastSize = 0
+	return nil
+}
+
+func (s *stringInput) ReadByte() (byte, error) {
+	s.lastSize = 0 // like bufio.Reader, a byte can't be unread as rune
+	if s.offset >= len(s.s) {
+		return 0, io.EOF
+	}
+	s.offset += 1
+	return s.s[s.offset-1], nil
+}
+
+func (s *stringInput) UnreadByte() error {
+	if s.offset == 0 {
+		return bufio.ErrInvalidUnreadByte
+	}
+	s.offset -= 1
+	s.lastSize = 0
+	return nil
+}
+
+func (s *stringInput) startsWith(prefix string) bool {
+	return strings.HasPrefix(s.s[s.offset:], prefix)
+}
+
+// newTokenizerConfig creates the config for given options and sets up the classifier.
+func newTokenizerConfig(opts ...Option) config {
+	c := newConfig(opts...)
+	modified := len(c.runeClasses) > 0 || !c.comments
+	switch {
+	case c.classifier == nil && modified:
+		c.classifier = NewDefaultClassifier()
+	case c.classifier == nil:
+		c.classifier, c.ascii = sharedDefaultClassifier()
+	case modified:
+		c.classifier = c.classifier.clone() // don't modify the classifier passed with WithClassifier
+	}
+	for _, runeClass := range c.runeClasses {
+		c.classifier.setRuneClass(runeClass.runes, runeClass.class)
+	}
+	if !c.comments {
+		c.classifier.setRuneClass("", COMMENT_RUNE_CLASS)
+	}
+	if c.ascii == nil {
+		c.ascii = c.classifier.asciiClasses()
+	}
+	return c
+}
+
+// Reset discards the state of the tokenizer and switches to reading from r.
+// The configuration (including the classifier) is kept so the tokenizer can be reused.
+func (t *Tokenizer) Reset(r io.Reader) {
+	input, ok := t.input.(readerInput)
+	if ok {
+		input.Reset(r)
+	} else {
+		input = readerInput{bufio.NewReader(r)}
+	}
+	t.reset(input)
+}
+
+// resetString is like Reset but switches to reading directly from a string.
+func (t *Tokenizer) resetString(s string) {
+	input, ok := t.input.(*stringInput)
+	if !ok {
+		input = &stringInput{}
+	}
+	*input = stringInput{s: s}
+	t.reset(input)
+}
+
+// reset discards the state of the tokenizer and switches to given input.
+func (t *Tokenizer) reset(input runeInput) {
+	*t = Tokenizer{
+		config:   t.config,
+		input:    input,
+		heredocs: t.heredocs[:0],
+		value:    t.value[:0],
+		raw:      t.raw[:0],
+	}
+}
+
+// Reset discards the state of the lexer and switches to reading from r (see Tokenizer.Reset).
+func (l *Lexer) Reset(r io.Reader) {
+	(*Tokenizer)(l).Reset(r)
 }
 
 // scanStream scans the stream for the next token using the internal state machine.
 // It will panic if it encounters a rune which it does not know how to handle.
-func (t *tokenizer) scanStream() (*Token, error) {
+func (t *Tokenizer) scanStream(token *Token) error {
 	previousState := t.state
 	t.state = START_STATE
-	token := &Token{}
+	t.tokenStart = t.index
+	defer func() { token.State = t.state }() // state in which the token ended (also on errors)
 	var nextRune rune
-	var nextRuneType runeTokenClass
+	var nextRuneType RuneTokenClass
 	var err error
 	consumed := 0
+	if t.commentNewline > 0 {
+		consumed = -t.commentNewline // the newline belongs to the comment and does not count as space before the end of input
+		t.commentNewline = 0
+	}
+
+	if t.heredocBody {
+		t.heredocBody = false
+		token.Type = HEREDOC_TOKEN
+		token.Index, token.ByteIndex = t.index, t.byteIndex
+		t.state = HEREDOC_STATE
+	}
 
 	for {
-		nextRune, _, err = t.ReadRune()
-		nextRuneType = t.classifier.ClassifyRune(nextRune)
-		token.RawValue += string(nextRune)
+		nextRune, _, err = t.readRune()
+		nextRuneType = t.classifyRune(nextRune)
+		token.addRaw(nextRune)
 		consumed += 1 // TODO find a nicer solution for this
 
 		switch {
@@ -249,14 +938,34 @@ func (t *tokenizer) scanStream() (*Token, error) {
 			nextRuneType = eofRuneClass
 			err = nil
 		case err != nil:
-			return nil, err
+			if _, ok := err.(*InvalidUTF8Error); ok {
+				return &LexError{Index: t.index, State: t.state, Msg: "invalid UTF-8", Err: err}
+			}
+			return &LexError{Index: t.index, State: t.state, Msg: "read error", Err: err}
 		}
 
 		switch t.state {
 		case START_STATE: // no runes read yet
 			{
-				if nextRuneType != spaceRuneClass {
-					token.Index = t.index - 1
+				if nextRune == byteOrderMark && t.index == 1 { // leading byte order mark is kept out of the first word (but counted by indexes)
+					token.removeLastRaw()
+					token.Leading += runeString(nextRune)
+					break
+				}
+				if nextRuneType != SPACE_RUNE_CLASS {
+					token.Index, token.ByteIndex = t.index-1, t.byteIndex-t.lastSize
+				}
+				if t.isGroup(nextRune) {
+					token.Type = GROUP_TOKEN
+					token.add(nextRune)
+					t.state = GROUP_STATE
+					return err
+				}
+				if t.fishSubstitution(nextRune) {
+					token.Type = WORD_TOKEN
+					t.state = IN_WORD_STATE
+					t.scanSubstitution(token, nextRune)
+					break
 				}
 				switch nextRuneType {
 				case eofRuneClass:
@@ -264,100 +973,217 @@ func (t *tokenizer) scanStream() (*Token, error) {
 					case t.index == 0: // tonkenizer contains an empty string
 						token.removeLastRaw()
 						token.Type = WORD_TOKEN
-						token.Index = t.index
+						token.Index, token.ByteIndex = t.index, t.byteIndex
 						t.index += 1
-						return token, nil // return an additional empty token for current cursor position
-					case previousState == WORDBREAK_STATE, consumed > 1: // consumed is greater than 1 when when there were spaceRunes before
+						return nil // return an additional empty token for current cursor position
+					case previousState == WORDBREAK_STATE, previousState == GROUP_STATE, previousState == SPACE_STATE, consumed > 1: // consumed is greater than 1 when when there were spaceRunes before
 						token.removeLastRaw()
 						token.Type = WORD_TOKEN
-						token.Index = t.index
-						return token, nil // return an additional empty token for current cursor position
+						token.Index, token.ByteIndex = t.index, t.byteIndex
+						return nil // return an additional empty token for current cursor position
 					default:
-						return nil, io.EOF
+						return io.EOF
 					}
-				case spaceRuneClass:
-					token.removeLastRaw()
-				case escapingQuoteRuneClass:
+				case SPACE_RUNE_CLASS:
+					if nextRune == '\n' {
+						t.argument = false // newline ends the command
+					}
+					switch {
+					case t.spaceTokens:
+						token.Type = SPACE_TOKEN
+						token.Index, token.ByteIndex = t.index-1, t.byteIndex-t.lastSize
+						token.add(nextRune)
+						t.state = SPACE_STATE
+						if nextRune == '\n' && len(t.heredocs) > 0 {
+							t.heredocBody = true
+							return err
+						}
+					case nextRune == '\n' && len(t.heredocs) > 0:
+						token.removeLastRaw()
+						token.Leading += runeString(nextRune)
+						token.Type = HEREDOC_TOKEN
+						token.Index, token.ByteIndex = t.index, t.byteIndex
+						t.state = HEREDOC_STATE
+					default:
+						token.removeLastRaw()
+						token.Leading += runeString(nextRune)
+					}
+				case ESCAPING_QUOTE_RUNE_CLASS:
 					token.Type = WORD_TOKEN
 					t.state = QUOTING_ESCAPING_STATE
-					token.WordbreakIndex = len(token.Value)
-				case nonEscapingQuoteRuneClass:
+					t.openQuote(token, nextRune)
+					token.WordbreakIndex = len(token.value)
+				case NON_ESCAPING_QUOTE_RUNE_CLASS:
 					token.Type = WORD_TOKEN
 					t.state = QUOTING_STATE
-					token.WordbreakIndex = len(token.Value)
-				case escapeRuneClass:
+					t.openQuote(token, nextRune)
+					token.WordbreakIndex = len(token.value)
+				case ESCAPE_RUNE_CLASS:
+					if newline := t.newlineAhead(); newline != "" && t.lineContinuation { // line continuation between words
+						token.removeLastRaw()
+						for range newline {
+							t.readRune()
+						}
+						token.Leading += "\\" + newline
+						break
+					}
 					token.Type = WORD_TOKEN
 					t.state = ESCAPING_STATE
-				case commentRuneClass:
+				case COMMENT_RUNE_CLASS:
 					token.Type = COMMENT_TOKEN
 					t.state = COMMENT_STATE
-				case wordbreakRuneClass:
+				case WORDBREAK_RUNE_CLASS:
+					if next, err := t.peekRune(); err == nil && next == '(' && (nextRune == '<' || nextRune == '>') {
+						token.Type = WORD_TOKEN // process substitution
+						t.state = IN_WORD_STATE
+						t.scanSubstitution(token, nextRune)
+						break
+					}
 					token.Type = WORDBREAK_TOKEN
 					token.add(nextRune)
 					t.state = WORDBREAK_STATE
+				case DOLLAR_RUNE_CLASS:
+					token.Type = WORD_TOKEN
+					t.state = IN_WORD_STATE
+					t.scanDollar(token, nextRune)
+				case BACKQUOTE_RUNE_CLASS:
+					token.Type = WORD_TOKEN
+					token.HasSubstitution = true
+					token.add(nextRune)
+					t.state = BACKQUOTING_STATE
+					t.openQuote(token, nextRune)
 				default:
 					token.Type = WORD_TOKEN
+					token.TildeExpandable = nextRune == '~'
+					token.HasGlob = strings.ContainsRune(globRunes, nextRune)
+					if nextRune == '!' && t.historyExpansion {
+						next, err := t.peekRune()
+						token.HistoryExpansion = err == nil && t.classifyRune(next) != SPACE_RUNE_CLASS && next != '=' && next != '('
+					}
 					token.add(nextRune)
 					t.state = IN_WORD_STATE
 				}
 			}
+		case SPACE_STATE:
+			switch {
+			case nextRuneType == SPACE_RUNE_CLASS && (nextRune != '\n' || len(t.heredocs) == 0):
+				token.add(nextRune)
+			default: // a newline starting a here-document body is returned as separate space token
+				token.removeLastRaw()
+				t.UnreadRune()
+				return err
+			}
 		case WORDBREAK_STATE:
-			switch nextRuneType {
-			case wordbreakRuneClass:
+			switch {
+			case nextRuneType == WORDBREAK_RUNE_CLASS && continuesOperator(token.value, nextRune):
+				token.add(nextRune)
+			case nextRune == '-' && strings.TrimLeft(string(token.value), digitRunes) == "<<": // `<<-`
 				token.add(nextRune)
 			default:
 				token.removeLastRaw()
 				t.UnreadRune()
-				return token, err
+				return err
 			}
 		case IN_WORD_STATE: // in a regular word
+			if t.fishSubstitution(nextRune) {
+				t.scanSubstitution(token, nextRune)
+				break
+			}
+			if nextRune == ')' && t.subshells > 0 { // closing subshell
+				token.removeLastRaw()
+				t.UnreadRune()
+				return err
+			}
 			switch nextRuneType {
-			case wordbreakRuneClass:
+			case WORDBREAK_RUNE_CLASS:
+				if (nextRune == '<' || nextRune == '>') && token.isFileDescriptor(nextRune) { // fd-qualified redirect (`2>`)
+					token.Type = WORDBREAK_TOKEN
+					token.add(nextRune)
+					t.state = WORDBREAK_STATE
+					break
+				}
 				token.removeLastRaw()
 				t.UnreadRune()
-				return token, err
-			case eofRuneClass, spaceRuneClass:
+				return err
+			case eofRuneClass, SPACE_RUNE_CLASS:
 				token.removeLastRaw()
 				t.UnreadRune()
-				return token, err
-			case escapingQuoteRuneClass:
+				return err
+			case ESCAPING_QUOTE_RUNE_CLASS:
 				t.state = QUOTING_ESCAPING_STATE
-				token.WordbreakIndex = len(token.Value)
-			case nonEscapingQuoteRuneClass:
+				t.openQuote(token, nextRune)
+				token.WordbreakIndex = len(token.value)
+			case NON_ESCAPING_QUOTE_RUNE_CLASS:
 				t.state = QUOTING_STATE
-				token.WordbreakIndex = len(token.Value)
-			case escapeRuneClass:
+				t.openQuote(token, nextRune)
+				token.WordbreakIndex = len(token.value)
+			case ESCAPE_RUNE_CLASS:
 				t.state = ESCAPING_STATE
+			case DOLLAR_RUNE_CLASS:
+				t.scanDollar(token, nextRune)
+			case BACKQUOTE_RUNE_CLASS:
+				token.HasSubstitution = true
+				token.add(nextRune)
+				t.state = BACKQUOTING_STATE
+				t.openQuote(token, nextRune)
 			default:
+				if strings.ContainsRune(globRunes, nextRune) {
+					token.HasGlob = true
+				}
 				token.add(nextRune)
 			}
 		case ESCAPING_STATE: // the rune after an escape character
 			switch nextRuneType {
 			case eofRuneClass: // EOF found after escape character
 				token.removeLastRaw()
-				return token, err
+				return t.unterminated(token)
 			default:
 				t.state = IN_WORD_STATE
-				token.add(nextRune)
+				if t.continuesLine(token, nextRune) { // line continuation is removed from the value
+					break
+				}
+				if t.dialect == FISH_DIALECT && strings.ContainsRune("abefnrtvxuU01234567", nextRune) {
+					t.scanANSICEscape(token, nextRune) // fish supports escape sequences outside of quotes
+					break
+				}
+				token.addEscaped(nextRune)
 			}
 		case ESCAPING_QUOTED_STATE: // the next rune after an escape character, in double quotes
 			switch nextRuneType {
 			case eofRuneClass: // EOF found after escape character
 				token.removeLastRaw()
-				return token, err
+				return t.unterminated(token)
 			default:
 				t.state = QUOTING_ESCAPING_STATE
-				token.add(nextRune)
+				if t.continuesLine(token, nextRune) { // line continuation is removed from the value
+					break
+				}
+				if t.dialect == FISH_DIALECT && !strings.ContainsRune("\"\\$\n", nextRune) {
+					token.addEscaped('\\') // fish only escapes `\"`, `\\`, `\$` and newline in double quotes
+					token.add(nextRune)
+					break
+				}
+				token.addEscaped(nextRune)
 			}
 		case QUOTING_ESCAPING_STATE: // in escaping double quotes
 			switch nextRuneType {
 			case eofRuneClass: // EOF found when expecting closing quote
 				token.removeLastRaw()
-				return token, err
-			case escapingQuoteRuneClass:
+				return t.unterminated(token)
+			case ESCAPING_QUOTE_RUNE_CLASS:
+				if nextRune != t.quote || t.scanDoubledQuote(token, nextRune) { // different or doubled quote rune
+					token.add(nextRune)
+					break
+				}
 				t.state = IN_WORD_STATE
-			case escapeRuneClass:
+			case ESCAPE_RUNE_CLASS:
 				t.state = ESCAPING_QUOTED_STATE
+			case DOLLAR_RUNE_CLASS:
+				if next, err := t.peekRune(); err == nil && next == '(' {
+					t.scanSubstitution(token, nextRune)
+				} else {
+					t.scanVariable(token, nextRune)
+				}
 			default:
 				token.add(nextRune)
 			}
@@ -365,46 +1191,711 @@ func (t *tokenizer) scanStream() (*Token, error) {
 			switch nextRuneType {
 			case eofRuneClass: // EOF found when expecting closing quote
 				token.removeLastRaw()
-				return token, err
-			case nonEscapingQuoteRuneClass:
+				return t.unterminated(token)
+			case NON_ESCAPING_QUOTE_RUNE_CLASS:
+				if nextRune != t.quote || t.scanDoubledQuote(token, nextRune) { // different or doubled quote rune
+					token.add(nextRune)
+					break
+				}
 				t.state = IN_WORD_STATE
+			case ESCAPE_RUNE_CLASS:
+				if next, err := t.peekRune(); err == nil && t.dialect == FISH_DIALECT && (next == t.quote || next == nextRune) {
+					t.consumeRune(token) // fish escapes `\'` and `\\` in single quotes
+					token.add(next)
+					break
+				}
+				token.add(nextRune)
 			default:
 				token.add(nextRune)
 			}
-		case COMMENT_STATE: // in a comment
+		case ANSI_C_QUOTING_STATE: // in ANSI-C quotes
 			switch nextRuneType {
-			case eofRuneClass:
-				return token, err
-			case spaceRuneClass:
-				if nextRune == '\n' {
-					token.removeLastRaw()
-					t.state = START_STATE
-					return token, err
-				} else {
-					token.add(nextRune)
-				}
+			case eofRuneClass: // EOF found when expecting closing quote
+				token.removeLastRaw()
+				return t.unterminated(token)
+			case NON_ESCAPING_QUOTE_RUNE_CLASS:
+				t.state = IN_WORD_STATE
+			case ESCAPE_RUNE_CLASS:
+				t.state = ESCAPING_ANSI_C_STATE
+			default:
+				token.add(nextRune)
+			}
+		case ESCAPING_ANSI_C_STATE: // the next rune after an escape character, in ANSI-C quotes
+			switch nextRuneType {
+			case eofRuneClass: // EOF found after escape character
+				token.removeLastRaw()
+				return t.unterminated(token)
+			default:
+				t.state = ANSI_C_QUOTING_STATE
+				t.scanANSICEscape(token, nextRune)
+			}
+		case BACKQUOTING_STATE: // in backquotes
+			switch nextRuneType {
+			case eofRuneClass: // EOF found when expecting closing backquote
+				token.removeLastRaw()
+				return t.unterminated(token)
+			case BACKQUOTE_RUNE_CLASS:
+				token.add(nextRune)
+				t.state = IN_WORD_STATE
+			case ESCAPE_RUNE_CLASS:
+				token.add(nextRune)
+				t.state = ESCAPING_BACKQUOTED_STATE
+			default:
+				token.add(nextRune)
+			}
+		case ESCAPING_BACKQUOTED_STATE: // the next rune after an escape character, in backquotes
+			switch nextRuneType {
+			case eofRuneClass: // EOF found after escape character
+				token.removeLastRaw()
+				return t.unterminated(token)
+			default:
+				token.add(nextRune)
+				t.state = BACKQUOTING_STATE
+			}
+		case SUBSTITUTION_STATE, ARITHMETIC_STATE: // EOF found within a command substitution or arithmetic expansion
+			token.removeLastRaw()
+			return t.unterminated(token)
+		case HEREDOC_STATE: // in the body of a here-document
+			switch {
+			case nextRuneType == eofRuneClass:
+				token.removeLastRaw()
+				if t.endOfHeredoc(token) {
+					t.state = START_STATE
+				}
+				return err
+			case nextRune == '\n':
+				if t.endOfHeredoc(token) {
+					token.removeLastRaw()
+					t.UnreadRune() // newline might start the next here-document
+					t.state = START_STATE
+					return err
+				}
+				token.add(nextRune)
+			case nextRune == '\t' && t.heredocs[0].stripTabs && (len(token.value) == 0 || token.value[len(token.value)-1] == '\n'):
+				// strip leading tab
+			default:
+				token.add(nextRune)
+			}
+		case COMMENT_STATE: // in a comment
+			switch nextRuneType {
+			case eofRuneClass:
+				token.removeLastRaw()
+				return err
+			case SPACE_RUNE_CLASS:
+				if nextRune == '\n' {
+					token.removeLastRaw()
+					t.UnreadRune() // newline might start a here-document body
+					t.commentNewline = 1
+					t.state = START_STATE
+					return err
+				} else {
+					token.add(nextRune)
+				}
 			default:
 				token.add(nextRune)
 			}
 		default:
-			return nil, fmt.Errorf("unexpected state: %v", t.state)
+			return &LexError{Index: t.index - 1, State: t.state, Msg: "unexpected state"}
+		}
+
+		if err := t.tooLong(token); err != nil {
+			return err
+		}
+		if t.state == COMMENT_STATE && t.newlineAhead() == "\r\n" { // the carriage return is not part of the comment
+			t.state = START_STATE
+			t.commentNewline = 2
+			return err
+		}
+	}
+}
+
+// tooLong returns an error if the token exceeds the maximum token length (see WithMaxTokenLength).
+// Leading spaces are limited separately from the token itself.
+func (t *Tokenizer) tooLong(token *Token) error {
+	if t.maxTokenLength <= 0 {
+		return nil
+	}
+	index := t.tokenStart
+	if token.Index > index {
+		index = token.Index // after leading spaces
+	}
+	if t.index-index <= t.maxTokenLength {
+		return nil
+	}
+	return &LexError{Index: index, State: t.state, Msg: "token too long", Err: &TokenTooLongError{Index: index, Limit: t.maxTokenLength}}
+}
+
+// continuesLine reports whether the escaped rune is the newline of a line continuation (see WithLineContinuation).
+// The newline of a Windows line ending is consumed along with the carriage return.
+func (t *Tokenizer) continuesLine(token *Token, r rune) bool {
+	switch {
+	case !t.lineContinuation:
+		return false
+	case r == '\n':
+		return true
+	case r == '\r' && t.newlineAhead() == "\n":
+		t.consumeRune(token)
+		return true
+	default:
+		return false
+	}
+}
+
+// newlineAhead returns the line ending (`\n` or `\r\n`) the input continues with or an empty string.
+func (t *Tokenizer) newlineAhead() string {
+	switch {
+	case t.input.startsWith("\n"):
+		return "\n"
+	case t.input.startsWith("\r\n"):
+		return "\r\n"
+	default:
+		return ""
+	}
+}
+
+// scanDollar handles a dollar rune within a word, which might start an ANSI-C quoted string ($'...')
+// or a locale-translated string ($"...").
+func (t *Tokenizer) scanDollar(token *Token, dollar rune) {
+	next, err := t.peekRune()
+	switch {
+	case err != nil:
+		t.scanVariable(token, dollar)
+	case !t.dollarQuotes && (next == '\'' || next == '"'):
+		token.add(dollar)
+	case next == '\'':
+		t.consumeRune(token)
+		t.state = ANSI_C_QUOTING_STATE
+		t.openQuote(token, next)
+		token.Quote = string(dollar) + token.Quote
+		token.WordbreakIndex = len(token.value)
+	case next == '"':
+		t.consumeRune(token)
+		t.state = QUOTING_ESCAPING_STATE
+		t.openQuote(token, next)
+		token.Quote = string(dollar) + token.Quote
+		token.WordbreakIndex = len(token.value)
+	case next == '(':
+		t.scanSubstitution(token, dollar)
+	default:
+		t.scanVariable(token, dollar)
+	}
+}
+
+// scanDoubledQuote consumes the second quote of a doubled quote if supported by the dialect.
+// A doubled quote is a literal quote within the quoted string:
+//
+//	'it''s'
+func (t *Tokenizer) scanDoubledQuote(token *Token, quote rune) bool {
+	if !t.doubledQuotes {
+		return false
+	}
+	if next, err := t.peekRune(); err != nil || next != quote {
+		return false
+	}
+	t.consumeRune(token)
+	return true
+}
+
+// scanVariable adds a dollar rune and a following variable reference ($VAR or ${VAR}) to the token.
+func (t *Tokenizer) scanVariable(token *Token, dollar rune) {
+	expansion := Expansion{Start: len(token.value)}
+	offsets := len(token.RawOffsets)
+	token.add(dollar)
+
+	next, err := t.peekRune()
+	switch {
+	case err != nil: // dollar rune at EOF
+	case next == '{':
+		depth := 0
+		for {
+			r, err := t.consumeRune(token)
+			if err != nil {
+				break
+			}
+			token.add(r)
+			if r == '{' {
+				depth += 1
+			} else if r == '}' {
+				if depth -= 1; depth == 0 {
+					break
+				}
+			}
+		}
+		expansion.Name = identifierPrefix(string(token.value[expansion.Start+2:]))
+	case isIdentifierRune(next, true):
+		for {
+			r, err := t.peekRune()
+			if err != nil || !isIdentifierRune(r, false) {
+				break
+			}
+			t.consumeRune(token)
+			token.add(r)
+		}
+		expansion.Name = string(token.value[expansion.Start+1:])
+	case strings.ContainsRune("*@#?$!-0123456789", next): // special parameter
+		t.consumeRune(token)
+		token.add(next)
+		return
+	default:
+		return
+	}
+	if t.varExpansion != nil {
+		t.expandVariable(token, expansion, offsets)
+	}
+	expansion.End = len(token.value)
+	token.Expansions = append(token.Expansions, expansion)
+}
+
+// expandVariable replaces given variable reference at the end of the value with its value (see WithVarExpansion).
+// The runes of the substituted value map to the dollar rune in RawValue.
+func (t *Tokenizer) expandVariable(token *Token, expansion Expansion, offsets int) {
+	switch reference := string(token.value[expansion.Start:]); reference {
+	case "$", "${}":
+		return
+	case "$" + expansion.Name, "${" + expansion.Name + "}":
+	default:
+		return // other parameter expansion or unclosed braces
+	}
+
+	value, ok := t.varExpansion(expansion.Name)
+	if !ok && t.keepUnknownVars {
+		return
+	}
+	dollar := token.RawOffsets[offsets]
+	token.value = token.value[:expansion.Start]
+	token.RawOffsets = token.RawOffsets[:offsets]
+	for _, r := range value {
+		token.value = appendRune(token.value, r)
+		token.RawOffsets = append(token.RawOffsets, dollar)
+	}
+}
+
+// isIdentifierRune checks whether given rune is valid within a shell variable name.
+func isIdentifierRune(r rune, first bool) bool {
+	switch {
+	case r == '_', r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z':
+		return true
+	case r >= '0' && r <= '9':
+		return !first
+	default:
+		return false
+	}
+}
+
+// identifierPrefix returns the leading shell variable name of given string.
+func identifierPrefix(s string) string {
+	for index, r := range s {
+		if !isIdentifierRune(r, index == 0) {
+			return s[:index]
+		}
+	}
+	return s
+}
+
+// scanSubstitution consumes a command ($(...)) or process substitution (<(...), >(...))
+// as well as an arithmetic expansion ($((...))) up to the matching closing parenthesis.
+// It is kept as is in the value of the token as the lexer does not perform any expansion.
+// At EOF the tokenizer is left in SUBSTITUTION_STATE or ARITHMETIC_STATE respectively.
+func (t *Tokenizer) scanSubstitution(token *Token, prefix rune) {
+	quoted := t.state == QUOTING_ESCAPING_STATE // substitution within double quotes
+	t.substitutionIndex = t.index - 1
+	token.add(prefix)
+	open := prefix // fish command substitution has no prefix
+	if prefix != '(' {
+		open, _ = t.consumeRune(token)
+		token.add(open)
+	}
+
+	eofState := SUBSTITUTION_STATE
+	if next, err := t.peekRune(); err == nil && next == '(' && prefix == '$' {
+		eofState = ARITHMETIC_STATE
+	} else {
+		token.HasSubstitution = true
+	}
+
+	closing := []rune{')'} // stack of expected closing runes
+	previous := open
+	escaping := false // EOF after an escape rune
+	for len(closing) > 0 {
+		r, err := t.consumeRune(token)
+		if err != nil {
+			t.state = eofState
+			if escaping {
+				token.closing = `\`
+			}
+			for index := len(closing) - 1; index >= 0; index-- {
+				token.closing += string(closing[index])
+			}
+			if quoted {
+				token.closing += string(t.quote)
+			}
+			return
+		}
+		token.add(r)
+
+		switch expected := closing[len(closing)-1]; {
+		case r == '\\' && expected != '\'':
+			if escaped, err := t.consumeRune(token); err == nil {
+				token.add(escaped)
+			} else {
+				escaping = true
+			}
+			r = 0
+		case r == expected:
+			closing = closing[:len(closing)-1]
+		case expected == '\'', expected == '`':
+		case r == '(' && previous == '$':
+			closing = append(closing, ')')
+		case expected == '"':
+		case r == '(':
+			closing = append(closing, ')')
+		case r == '\'', r == '"', r == '`':
+			closing = append(closing, r)
+		}
+		previous = r
+	}
+}
+
+// scanANSICEscape adds the rune(s) denoted by the escape sequence starting with given rune.
+func (t *Tokenizer) scanANSICEscape(token *Token, r rune) {
+	start, count := token.rawLength-2, len(token.RawOffsets)
+	defer func() {
+		for index := count; index < len(token.RawOffsets); index++ {
+			token.RawOffsets[index] = start // escape sequence begins at the escape rune
+		}
+	}()
+
+	switch r {
+	case 'a':
+		token.add('\a')
+	case 'b':
+		token.add('\b')
+	case 'e', 'E':
+		token.add('\x1b')
+	case 'f':
+		token.add('\f')
+	case 'n':
+		token.add('\n')
+	case 'r':
+		token.add('\r')
+	case 't':
+		token.add('\t')
+	case 'v':
+		token.add('\v')
+	case '\\', '\'', '"', '?':
+		token.add(r)
+	case 'x':
+		t.scanANSICNumber(token, r, 16, 2)
+	case 'u':
+		t.scanANSICNumber(token, r, 16, 4)
+	case 'U':
+		t.scanANSICNumber(token, r, 16, 8)
+	case '0', '1', '2', '3', '4', '5', '6', '7':
+		t.UnreadRune()
+		token.removeLastRaw()
+		t.scanANSICNumber(token, r, 8, 3)
+	default: // unknown escape sequences are kept as is
+		token.add('\\')
+		token.add(r)
+	}
+}
+
+// scanANSICNumber adds the rune denoted by up to max digits in given base.
+// The escape sequence is kept as is when no digit follows.
+func (t *Tokenizer) scanANSICNumber(token *Token, prefix rune, base, max int) {
+	digits := ""
+	for len(digits) < max {
+		r, err := t.peekRune()
+		if err != nil {
+			break
+		}
+		if _, err := strconv.ParseUint(string(r), base, 8); err != nil {
+			break
 		}
+		t.consumeRune(token)
+		digits += string(r)
+	}
+
+	if digits == "" {
+		token.add('\\')
+		token.add(prefix)
+		return
+	}
+	value, _ := strconv.ParseUint(digits, base, 32)
+	token.add(rune(value))
+}
+
+// endOfHeredoc checks whether the current line of the here-document body is its delimiter
+// and if so removes the line from the body and pops the here-document.
+func (t *Tokenizer) endOfHeredoc(token *Token) bool {
+	lineStart := bytes.LastIndexByte(token.value, '\n') + 1
+	line := bytes.TrimSuffix(token.value[lineStart:], []byte{'\r'}) // Windows line ending
+	if string(line) != t.heredocs[0].delimiter {
+		return false
+	}
+	token.value = token.value[:lineStart]
+	token.RawOffsets = token.RawOffsets[:utf8.RuneCount(token.value)]
+	t.heredocs = t.heredocs[1:]
+	return true
+}
+
+// fishSubstitution checks whether given (unquoted) rune opens a fish command substitution (`(cmd)`).
+func (t *Tokenizer) fishSubstitution(r rune) bool {
+	return r == '(' && t.dialect == FISH_DIALECT
+}
+
+// isGroup checks whether given rune opens or closes a subshell or brace group at the current position.
+// Braces are reserved words and thus need to be followed by a space.
+func (t *Tokenizer) isGroup(r rune) bool {
+	if !t.groups {
+		return false
+	}
+	switch r {
+	case '(':
+		return !t.argument
+	case ')':
+		return t.subshells > 0
+	case '{', '}':
+		if t.argument {
+			return false
+		}
+		next, err := t.peekRune()
+		return err != nil || t.classifyRune(next) == SPACE_RUNE_CLASS
+	default:
+		return false
+	}
+}
+
+// continuesOperator reports whether the rune extends the wordbreak operator scanned so far.
+// Pipeline delimiters and case terminators end once complete, so `a|&&b` is `|&` followed by `&`
+// instead of a single run of wordbreak runes. Other wordbreaks are consumed greedily.
+func continuesOperator(value []byte, r rune) bool {
+	switch string(value) {
+	case "|":
+		return r == '&' || r == '|'
+	case "&":
+		return r == '&' || r == '>' // `&>`
+	case "&>":
+		return r == '>' // `&>>`
+	case ";":
+		return r == ';' || r == '&'
+	case ";;":
+		return r == '&'
+	case "|&", "||", "&&", "&>>", ";&", ";;&":
+		return false
+	default:
+		return true
+	}
+}
+
+// trackCommandPosition keeps track of whether the next word is in command position.
+func (t *Tokenizer) trackCommandPosition(token Token) {
+	switch {
+	case token.Type == WORD_TOKEN:
+		t.argument = true
+	case token.Type == COMMENT_TOKEN:
+		t.argument = false // comments extend to the end of the line
+	case token.Type == WORDBREAK_TOKEN && token.WordbreakType.IsPipelineDelimiter():
+		t.argument = false
+	case token.Type == GROUP_TOKEN && token.Value == "(":
+		t.subshells += 1
+		t.argument = false
+	case token.Type == GROUP_TOKEN && token.Value == ")":
+		t.subshells -= 1
+		t.argument = true
+	case token.Type == GROUP_TOKEN && token.Value == "{":
+		t.argument = false
+	case token.Type == GROUP_TOKEN && token.Value == "}":
+		t.argument = true
+	}
+}
+
+// trackHeredoc registers the delimiter word following a here-document operator.
+func (t *Tokenizer) trackHeredoc(token Token) {
+	switch {
+	case token.Type == WORDBREAK_TOKEN && token.WordbreakType == WORDBREAK_REDIRECT_INPUT_HEREDOC:
+		t.heredoc = &heredoc{stripTabs: token.redirectOperator() == "<<-"}
+	case t.heredoc != nil && token.Type == WORD_TOKEN && token.RawValue != "":
+		t.heredoc.delimiter = token.Value
+		t.heredocs = append(t.heredocs, *t.heredoc)
+		t.heredoc = nil
+	default:
+		t.heredoc = nil
 	}
 }
 
 // Next returns the next token in the stream.
-func (t *tokenizer) Next() (*Token, error) {
-	token, err := t.scanStream()
+// Tokens given to PushBack are returned first (last in, first out).
+func (t *Tokenizer) Next() (*Token, error) {
+	if count := len(t.pushedBack); count > 0 {
+		token := t.pushedBack[count-1]
+		t.pushedBack = t.pushedBack[:count-1]
+		return token, nil
+	}
+	if t.pending != nil {
+		return t.receive(context.Background())
+	}
+
+	token := &Token{}
+	if err := t.next(token); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// nextInto is like Next but copies the token into given one.
+func (t *Tokenizer) nextInto(token *Token) error {
+	if count := len(t.pushedBack); count > 0 {
+		*token = *t.pushedBack[count-1]
+		t.pushedBack = t.pushedBack[:count-1]
+		return nil
+	}
+	if t.pending != nil {
+		pending, err := t.receive(context.Background())
+		if err != nil {
+			return err
+		}
+		*token = *pending
+		return nil
+	}
+	return t.next(token)
+}
+
+// scanResult is the outcome of scanning a token in the background (see NextContext).
+type scanResult struct {
+	token *Token
+	err   error
+}
+
+// NextContext is like Next but returns ctx.Err() as soon as the context is done,
+// even while waiting on the underlying reader (e.g. a pipe or network stream that delivers no data).
+//
+// Scanning continues in the background and the token is returned by the following call to Next or NextContext,
+// so no input is lost. The tokenizer must not be reset while such a scan is pending.
+func (t *Tokenizer) NextContext(ctx context.Context) (*Token, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if len(t.pushedBack) > 0 {
+		return t.Next()
+	}
+	if t.pending == nil {
+		pending := make(chan scanResult, 1)
+		t.pending = pending
+		go func() {
+			token := &Token{}
+			err := t.next(token)
+			pending <- scanResult{token, err}
+		}()
+	}
+	return t.receive(ctx)
+}
+
+// receive waits for the pending token or until the context is done.
+func (t *Tokenizer) receive(ctx context.Context) (*Token, error) {
+	select {
+	case result := <-t.pending:
+		t.pending = nil
+		if result.err != nil {
+			return nil, result.err
+		}
+		return result.token, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// next scans the next token into given token.
+// Its RawOffsets are reused and Value and RawValue are kept if unchanged to avoid allocations (see SplitInto).
+func (t *Tokenizer) next(token *Token) error {
+	value, rawValue := token.Value, token.RawValue
+	*token = Token{value: t.value, raw: t.raw, RawOffsets: token.RawOffsets[:0]}
+	if err := t.scanStream(token); err != nil {
+		return err
+	}
+	if err := t.tooLong(token); err != nil {
+		return err
+	}
+	if t.tokens += 1; t.maxTokens > 0 && t.tokens > t.maxTokens {
+		return &LexError{Index: token.Index, State: t.state, Msg: "too many tokens", Err: &TooManyTokensError{Index: token.Index, Limit: t.maxTokens}}
+	}
+
+	t.value, t.raw = token.value[:0], token.raw[:0] // reuse the buffers for the next token
+	token.materialize(value, rawValue)
+	token.EndIndex = token.Index + utf8.RuneCountInString(token.RawValue)
+	token.ByteEndIndex = t.byteIndex // RawValue differs from the input for replaced invalid UTF-8
+	token.WordbreakType = wordbreakType(*token)
+	if token.WordbreakType.IsPipelineDelimiter() && strings.Trim(token.RawValue, t.pipelineRunes) != "" {
+		token.WordbreakType = WORDBREAK_UNKNOWN // not a configured pipeline rune
+	}
+	t.trackHeredoc(*token)
+	t.trackCommandPosition(*token)
+	if t.rawWords && token.Type == WORD_TOKEN {
+		t.keepRaw(token)
+	} else if t.tildeExpansion != nil && token.TildeExpandable {
+		t.expandTilde(token)
+	}
+	return nil
+}
+
+// expandTilde replaces the tilde prefix of the word with the home directory (see WithTildeExpansion).
+// The runes of the home directory map to the tilde in RawValue.
+func (t *Tokenizer) expandTilde(token *Token) {
+	prefix := token.RawValue
+	if index := strings.IndexRune(prefix, '/'); index >= 0 {
+		prefix = prefix[:index]
+	}
+	if !strings.HasPrefix(token.Value, prefix) || strings.ContainsAny(prefix, "$`") {
+		return // quoted, escaped or expanded runes within the prefix
+	}
+	home, ok := t.tildeExpansion(prefix[1:])
+	if !ok {
+		return
+	}
+
+	offsets := make([]int, utf8.RuneCountInString(home), len(token.RawOffsets)+len(home))
+	token.RawOffsets = append(offsets, token.RawOffsets[utf8.RuneCountInString(prefix):]...)
+	for index := range token.Expansions {
+		token.Expansions[index].Start += len(home) - len(prefix)
+		token.Expansions[index].End += len(home) - len(prefix)
+	}
+	token.Value = home + token.Value[len(prefix):]
+}
+
+// Peek returns the next token without consuming it.
+// Errors are not buffered, so a subsequent Next returns them again.
+func (t *Tokenizer) Peek() (*Token, error) {
+	token, err := t.Next()
 	if err == nil {
-		token.State = t.state // TODO should be done in scanStream
-		token.WordbreakType = wordbreakType(*token)
+		t.PushBack(token)
 	}
 	return token, err
 }
 
+// PushBack returns the token to the stream so that the next call to Next returns it.
+// The tokenizer state (index, heredocs, command position) is not rewound,
+// so only tokens previously returned by Next should be pushed back.
+func (t *Tokenizer) PushBack(token *Token) {
+	t.pushedBack = append(t.pushedBack, token)
+}
+
+// keepRaw sets the value of the token to its raw value (see WithRawWords).
+func (t *Tokenizer) keepRaw(token *Token) {
+	// index of the last opening quote within the raw value
+	if offset := t.quoteIndex - token.Index; offset >= 0 && offset <= utf8.RuneCountInString(token.RawValue) {
+		token.WordbreakIndex = len(string([]rune(token.RawValue)[:offset]))
+	}
+	token.Value = token.RawValue
+	token.RawOffsets = make([]int, token.rawLength)
+	for index := range token.RawOffsets {
+		token.RawOffsets[index] = index
+	}
+	token.Expansions = nil
+}
+
 // Split partitions of a string into tokens.
-func Split(s string) (TokenSlice, error) {
-	l := newLexer(strings.NewReader(s))
+func Split(s string, opts ...Option) (TokenSlice, error) {
+	l := (*Lexer)(NewTokenizerString(s, opts...))
 	tokens := make(TokenSlice, 0)
 	for {
 		token, err := l.Next()
@@ -418,24 +1909,409 @@ func Split(s string) (TokenSlice, error) {
 	}
 }
 
+// splitters are the tokenizers reused by SplitInto.
+var splitters = sync.Pool{New: func() interface{} { return &Tokenizer{} }}
+
+// SplitInto is like Split but appends the tokens to dst, reusing its capacity.
+// Tokens within the capacity of dst are overwritten, and their strings and RawOffsets reused where possible,
+// so splitting into the result of a previous call allocates little to nothing for typical lines.
+// On error dst is returned unchanged.
+func SplitInto(s string, dst TokenSlice, opts ...Option) (TokenSlice, error) {
+	tokenizer := splitters.Get().(*Tokenizer)
+	defer splitters.Put(tokenizer)
+
+	tokenizer.config = newTokenizerConfig(opts...)
+	tokenizer.resetString(s)
+
+	tokens := dst
+	for {
+		if len(tokens) < cap(tokens) {
+			tokens = tokens[:len(tokens)+1]
+		} else {
+			tokens = append(tokens, Token{})
+		}
+		if err := skipTokens(&tokens[len(tokens)-1], tokenizer.nextInto); err != nil {
+			if err == io.EOF {
+				return tokens[:len(tokens)-1], nil
+			}
+			return dst, err
+		}
+	}
+}
+
+// Tokenize partitions a string into a sequence of tokens.
+// Unlike Split it keeps comments (and spaces when enabled with WithSpaceTokens).
+func Tokenize(s string, opts ...Option) (TokenSlice, error) {
+	t := NewTokenizerString(s, opts...)
+	tokens := make(TokenSlice, 0)
+	for {
+		token, err := t.Next()
+		if err != nil {
+			if err == io.EOF {
+				return tokens, nil
+			}
+			return nil, err
+		}
+		tokens = append(tokens, *token)
+	}
+}
+
+// ScanTokens returns a split function for bufio.Scanner which yields the raw value of successive tokens.
+// Comments and spaces are skipped like with the Lexer, so scanner.Text() returns shell words and operators from any reader.
+//
+// A token is only returned once it is known to be complete, which requires more data while it extends to the end of
+// the buffer. Tokens larger than the maximum buffer size of the scanner thus fail with bufio.ErrTooLong (see bufio.Scanner.Buffer).
+// The split function keeps the state of the tokenizer (e.g. pending here-documents) and must not be shared between scanners.
+func ScanTokens(opts ...Option) bufio.SplitFunc {
+	tokenizer := NewTokenizer(nil, opts...)
+	reader := &bytes.Reader{}
+	token := &Token{}
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		saved := *tokenizer
+		saved.heredocs = append([]heredoc(nil), tokenizer.heredocs...)
+
+		reader.Reset(data)
+		tokenizer.input.(readerInput).Reset(reader)
+		tokenizer.index, tokenizer.byteIndex = 0, 0
+		err := skipTokens(token, tokenizer.nextInto)
+		switch {
+		case !atEOF && (err != nil || token.ByteEndIndex == len(data)): // token might continue
+			*tokenizer = saved
+			return 0, nil, nil
+		case err == io.EOF:
+			return len(data), nil, nil
+		case err != nil:
+			return 0, nil, err
+		case token.RawValue == "":
+			return token.ByteEndIndex, nil, nil // empty word at the end of the input
+		default:
+			return token.ByteEndIndex, []byte(token.RawValue), nil
+		}
+	}
+}
+
+// SplitCursor partitions a string into a sequence of tokens up to the cursor (rune index).
+// The token containing the cursor only reflects the part before it (e.g. an unclosed quote)
+// and an empty token is added if the cursor is not within a word.
+func SplitCursor(s string, cursor int, opts ...Option) (TokenSlice, error) {
+	runes := []rune(s)
+	switch {
+	case cursor < 0:
+		cursor = 0
+	case cursor > len(runes):
+		cursor = len(runes)
+	}
+	return Split(string(runes[:cursor]), opts...)
+}
+
+// SplitByteCursor is like SplitCursor but with the cursor as byte offset (as provided by shells).
+// A cursor within a multi-byte rune is moved to its start.
+func SplitByteCursor(s string, cursor int, opts ...Option) (TokenSlice, error) {
+	switch {
+	case cursor < 0:
+		cursor = 0
+	case cursor > len(s):
+		cursor = len(s)
+	}
+	for cursor > 0 && cursor < len(s) && !utf8.RuneStart(s[cursor]) {
+		cursor--
+	}
+	return Split(s[:cursor], opts...)
+}
+
+// SplitLines partitions the input into the tokens of each logical line.
+// Unquoted newlines separate lines while quoted or escaped newlines (and here-documents) are part of a token,
+// so a line can span several lines of the input. Empty lines and lines only containing comments are omitted.
+// Indexes of the tokens are relative to the whole input. Errors are returned as LineError.
+func SplitLines(r io.Reader, opts ...Option) ([]TokenSlice, error) {
+	counter := &lineCounter{reader: r}
+	l := NewLexer(counter, opts...)
+	lines := make([]TokenSlice, 0)
+	line := make(TokenSlice, 0)
+	for {
+		token, err := l.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			index := counter.runes
+			var lexError *LexError
+			if errors.As(err, &lexError) {
+				index = lexError.Index
+			}
+			return nil, &LineError{Line: counter.line(index), Err: err}
+		}
+
+		switch {
+		case token.Type == WORD_TOKEN && token.RawValue == "":
+			continue // empty word at the end of the input
+		case token.Type != HEREDOC_TOKEN && unescapedNewline(token.Leading) && len(line) > 0:
+			lines = append(lines, line)
+			line = make(TokenSlice, 0)
+		}
+		line = append(line, *token)
+	}
+	if len(line) > 0 {
+		lines = append(lines, line)
+	}
+	return lines, nil
+}
+
+// unescapedNewline checks whether the skipped runes before a token contain a newline ending the line.
+// Escaped newlines (line continuation) are skipped, whereas the newline ending a comment ends the line.
+func unescapedNewline(leading string) bool {
+	comment := false
+	for index := 0; index < len(leading); index++ {
+		switch {
+		case leading[index] == '\n':
+			return true
+		case comment:
+		case leading[index] == '#':
+			comment = true
+		case leading[index] == '\\':
+			index += 1 // escaped newline (line continuation with LF or CRLF)
+			if strings.HasPrefix(leading[index:], "\r\n") {
+				index += 1
+			}
+		}
+	}
+	return false
+}
+
+// lineCounter records the rune index of each newline read through it (see SplitLines).
+type lineCounter struct {
+	reader   io.Reader
+	runes    int   // number of runes read
+	newlines []int // rune indexes of newlines
+}
+
+func (c *lineCounter) Read(p []byte) (int, error) {
+	n, err := c.reader.Read(p)
+	for _, b := range p[:n] {
+		if b == '\n' {
+			c.newlines = append(c.newlines, c.runes)
+		}
+		if !utf8.RuneStart(b) {
+			continue // continuation byte
+		}
+		c.runes += 1
+	}
+	return n, err
+}
+
+// line returns the line number (starting at 1) of given rune index.
+func (c *lineCounter) line(index int) int {
+	return sort.SearchInts(c.newlines, index) + 1
+}
+
+// SplitN partitions a string into a sequence of tokens up to n words (adjoining tokens form a word, see TokenSlice.Words).
+// It returns the tokens along with the remainder of the input, which starts after the spaces following the n-th word.
+// For n <= 0 no tokens are returned and the remainder is the whole input.
+func SplitN(s string, n int, opts ...Option) (TokenSlice, string, error) {
+	tokens := make(TokenSlice, 0)
+	if n <= 0 {
+		return tokens, s, nil
+	}
+
+	l := (*Lexer)(NewTokenizerString(s, opts...))
+	words := 0
+	for {
+		token, err := l.Next()
+		if err != nil {
+			if err == io.EOF {
+				return tokens, "", nil
+			}
+			return nil, "", err
+		}
+
+		if len(tokens) == 0 || !tokens[len(tokens)-1].Adjoins(token) {
+			if words == n {
+				end := tokens[len(tokens)-1].EndIndex
+				remainder := s[len(string([]rune(s)[:end])):]
+				return tokens, strings.TrimLeft(remainder, spaceRunes), nil
+			}
+			words++
+		}
+		tokens = append(tokens, *token)
+	}
+}
+
+// Quote returns the string quoted (if necessary) so that it is split into a single word.
+func Quote(s string) string {
+	switch {
+	case s == "":
+		return "''"
+	case !strings.ContainsAny(s, requoteRunes):
+		return s
+	default:
+		return Token{Quote: "'"}.Requote(s)
+	}
+}
+
+// EscapeFor escapes given string for insertion at the end of a word in given state,
+// so that it is appended to the value as is (e.g. `tokens.LastWord().State` for a completion candidate).
+// Opened quotes are not closed.
+func EscapeFor(state LexerState, s string) string {
+	if s == "" {
+		return ""
+	}
+
+	switch state {
+	case QUOTING_ESCAPING_STATE:
+		return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`, "`", "\\`").Replace(s)
+	case QUOTING_STATE:
+		return strings.Replace(s, "'", `'\''`, -1)
+	case ANSI_C_QUOTING_STATE:
+		return strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(s)
+	case ESCAPING_STATE, ESCAPING_BACKQUOTED_STATE:
+		_, size := utf8.DecodeRuneInString(s)
+		return s[:size] + EscapeFor(IN_WORD_STATE, s[size:])
+	case ESCAPING_QUOTED_STATE:
+		_, size := utf8.DecodeRuneInString(s)
+		return s[:size] + EscapeFor(QUOTING_ESCAPING_STATE, s[size:])
+	case ESCAPING_ANSI_C_STATE:
+		r, size := utf8.DecodeRuneInString(s)
+		return fmt.Sprintf("U%08X", r) + EscapeFor(ANSI_C_QUOTING_STATE, s[size:])
+	case COMMENT_STATE, HEREDOC_STATE:
+		return s
+	default:
+		return BACKSLASH_JOIN_STYLE.quote(s)
+	}
+}
+
+// Unquote returns the value of a single (quoted) word.
+// It fails for unclosed quotes, trailing escapes and multiple words.
+func Unquote(s string, opts ...Option) (string, error) {
+	tokens, err := Split(s, append(opts, WithStrict(true))...)
+	if err != nil {
+		return "", err
+	}
+
+	for _, token := range tokens {
+		if token.Type == GROUP_TOKEN || token.WordbreakType.IsPipelineDelimiter() || token.WordbreakType.IsRedirect() {
+			return "", &MultipleWordsError{Index: token.Index} // operators separate words
+		}
+	}
+
+	value := ""
+	for index, word := range tokens.Words() {
+		switch {
+		case word.RawValue == "":
+			continue // trailing empty word
+		case index > 0:
+			return "", &MultipleWordsError{Index: word.Index}
+		}
+		value = word.Value
+	}
+	return value, nil
+}
+
 // Join concatenates words to create a single string.
 // It quotes and escapes where appropriate.
 // TODO experimental
-func Join(s []string) string {
-	replacer := strings.NewReplacer(
-		"$", "\\$",
-		"`", "\\`",
-	)
-
+func Join(s []string, opts ...Option) string {
+	c := newConfig(opts...)
 	formatted := make([]string, 0, len(s))
 	for _, arg := range s {
-		switch {
-		case arg == "",
-			strings.ContainsAny(arg, `"' `+"\n\r\t"):
-			formatted = append(formatted, replacer.Replace(fmt.Sprintf("%#v", arg)))
-		default:
-			formatted = append(formatted, arg)
+		formatted = append(formatted, c.quote(arg))
+	}
+	return strings.Join(formatted, " ")
+}
+
+// quote quotes and escapes a word for Join.
+func (c config) quote(arg string) string {
+	switch {
+	case c.dialect == CMD_DIALECT:
+		if arg == "" || strings.ContainsAny(arg, " \t\r\n\"&|<>^()") {
+			arg = `"` + strings.Replace(arg, `"`, `"^""`, -1) + `"` // double quotes can only be escaped outside of quotes
+		}
+		return arg
+	case c.dialect == POWERSHELL_DIALECT:
+		if arg == "" || strings.ContainsAny(arg, " \t\r\n'\"`$;|&(){}@#,<>") {
+			arg = "'" + strings.Replace(arg, "'", "''", -1) + "'" // single quotes only need doubled single quotes
+		}
+		return arg
+	case !strings.ContainsRune(c.runes(ESCAPE_RUNE_CLASS, escapeRunes), '\\'):
+		if arg == "" || strings.ContainsAny(arg, `"' `+"\n\r\t|&;<>()#") {
+			// without escape runes a double quote can only be added in single quotes
+			arg = `"` + strings.Replace(arg, `"`, `"'"'"`, -1) + `"`
 		}
+		return arg
+	default:
+		return DEFAULT_JOIN_STYLE.quote(arg)
+	}
+}
+
+// QuoteDialect returns the string quoted (if necessary) for given dialect so that it is split into a single word.
+func QuoteDialect(s string, d Dialect) string {
+	switch d {
+	case BASH_DIALECT, ZSH_DIALECT:
+		return Quote(s)
+	case FISH_DIALECT:
+		if s != "" && !strings.ContainsAny(s, requoteRunes) {
+			return s
+		}
+		// fish supports escaping `\` and `'` within single quotes
+		return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(s) + "'"
+	default:
+		return newConfig(WithDialect(d)).quote(s)
+	}
+}
+
+// JoinDialect concatenates words quoted for given dialect (see QuoteDialect).
+func JoinDialect(s []string, d Dialect) string {
+	formatted := make([]string, 0, len(s))
+	for _, arg := range s {
+		formatted = append(formatted, QuoteDialect(arg, d))
+	}
+	return strings.Join(formatted, " ")
+}
+
+// JoinStyle is the quoting style used by JoinWith.
+type JoinStyle int
+
+const (
+	DEFAULT_JOIN_STYLE      JoinStyle = iota // double quotes
+	MINIMAL_JOIN_STYLE                       // shortest of the other styles (preferring single quotes)
+	SINGLE_QUOTE_JOIN_STYLE                  // single quotes
+	BACKSLASH_JOIN_STYLE                     // backslashes (newlines in single quotes)
+)
+
+// JoinWith concatenates words using given quoting style.
+// Words are only quoted when necessary.
+func JoinWith(s []string, style JoinStyle) string {
+	formatted := make([]string, 0, len(s))
+	for _, arg := range s {
+		formatted = append(formatted, style.quote(arg))
 	}
 	return strings.Join(formatted, " ")
 }
+
+func (s JoinStyle) quote(arg string) string {
+	switch {
+	case arg == "" && s == DEFAULT_JOIN_STYLE:
+		return `""`
+	case arg == "":
+		return "''"
+	case !strings.ContainsAny(arg, joinRunes):
+		return arg
+	}
+
+	switch s {
+	case MINIMAL_JOIN_STYLE:
+		quoted := SINGLE_QUOTE_JOIN_STYLE.quote(arg)
+		for _, style := range []JoinStyle{BACKSLASH_JOIN_STYLE, DEFAULT_JOIN_STYLE} {
+			if candidate := style.quote(arg); len(candidate) < len(quoted) {
+				quoted = candidate
+			}
+		}
+		return quoted
+	case SINGLE_QUOTE_JOIN_STYLE:
+		return Token{Quote: "'"}.Requote(arg)
+	case BACKSLASH_JOIN_STYLE:
+		// backslash followed by newline is a line continuation
+		return strings.Replace(Token{}.Requote(arg), "\\\n", "'\n'", -1)
+	default:
+		return Token{Quote: `"`}.Requote(arg)
+	}
+}
@@ -17,9 +17,21 @@ limitations under the License.
 package shlex
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"os"
+	"reflect"
 	"strings"
 	"testing"
+	"testing/iotest"
+	"testing/quick"
+	"time"
+	"unicode/utf8"
 )
 
 var (
@@ -29,12 +41,14 @@ var (
 )
 
 func TestClassifier(t *testing.T) {
-	classifier := newDefaultClassifier()
-	tests := map[rune]runeTokenClass{
-		' ':  spaceRuneClass,
-		'"':  escapingQuoteRuneClass,
-		'\'': nonEscapingQuoteRuneClass,
-		'#':  commentRuneClass}
+	classifier := NewDefaultClassifier()
+	tests := map[rune]RuneTokenClass{
+		' ':  SPACE_RUNE_CLASS,
+		'"':  ESCAPING_QUOTE_RUNE_CLASS,
+		'\'': NON_ESCAPING_QUOTE_RUNE_CLASS,
+		'#':  COMMENT_RUNE_CLASS,
+		'$':  DOLLAR_RUNE_CLASS,
+		'`':  BACKQUOTE_RUNE_CLASS}
 	for runeChar, want := range tests {
 		got := classifier.ClassifyRune(runeChar)
 		if got != want {
@@ -50,30 +64,30 @@ func init() {
 func TestTokenizer(t *testing.T) {
 	testInput := strings.NewReader(testString)
 	expectedTokens := []*Token{
-		{WORD_TOKEN, "one", "one", 0, IN_WORD_STATE, WORDBREAK_UNKNOWN, 0},
-		{WORD_TOKEN, "two", "two", 4, IN_WORD_STATE, WORDBREAK_UNKNOWN, 0},
-		{WORD_TOKEN, "three four", "\"three four\"", 8, IN_WORD_STATE, WORDBREAK_UNKNOWN, 0},
-		{WORD_TOKEN, "five \"six\"", "\"five \\\"six\\\"\"", 21, IN_WORD_STATE, WORDBREAK_UNKNOWN, 0},
-		{WORD_TOKEN, "seven#eight", "seven#eight", 36, IN_WORD_STATE, WORDBREAK_UNKNOWN, 0},
-		{COMMENT_TOKEN, " nine # ten", "# nine # ten", 48, START_STATE, WORDBREAK_UNKNOWN, 0},
-		{WORD_TOKEN, "eleven", "eleven", 62, IN_WORD_STATE, WORDBREAK_UNKNOWN, 0},
-		{WORD_TOKEN, "twelve\\", "'twelve\\'", 69, IN_WORD_STATE, WORDBREAK_UNKNOWN, 0},
-		{WORD_TOKEN, "thirteen", "thirteen", 79, IN_WORD_STATE, WORDBREAK_UNKNOWN, 0},
-		{WORDBREAK_TOKEN, "=", "=", 87, WORDBREAK_STATE, WORDBREAK_UNKNOWN, 0},
-		{WORD_TOKEN, "13", "13", 88, IN_WORD_STATE, WORDBREAK_UNKNOWN, 0},
-		{WORD_TOKEN, "fourteen/14", "fourteen/14", 91, IN_WORD_STATE, WORDBREAK_UNKNOWN, 0},
-		{WORDBREAK_TOKEN, "|", "|", 103, WORDBREAK_STATE, WORDBREAK_PIPE, 0},
-		{WORDBREAK_TOKEN, "||", "||", 105, WORDBREAK_STATE, WORDBREAK_LIST_OR, 0},
-		{WORDBREAK_TOKEN, "|", "|", 108, WORDBREAK_STATE, WORDBREAK_PIPE, 0},
-		{WORD_TOKEN, "after", "after", 109, IN_WORD_STATE, WORDBREAK_UNKNOWN, 0},
-		{WORD_TOKEN, "before", "before", 115, IN_WORD_STATE, WORDBREAK_UNKNOWN, 0},
-		{WORDBREAK_TOKEN, "|", "|", 121, WORDBREAK_STATE, WORDBREAK_PIPE, 0},
-		{WORDBREAK_TOKEN, "&", "&", 123, WORDBREAK_STATE, WORDBREAK_LIST_ASYNC, 0},
-		{WORDBREAK_TOKEN, ";", ";", 125, WORDBREAK_STATE, WORDBREAK_LIST_SEQUENTIAL, 0},
-		{WORD_TOKEN, "", "", 126, START_STATE, WORDBREAK_UNKNOWN, 0},
-	}
-
-	tokenizer := newTokenizer(testInput)
+		{Type: WORD_TOKEN, Value: "one", RawValue: "one", Index: 0, EndIndex: 3, ByteIndex: 0, ByteEndIndex: 3, State: IN_WORD_STATE},
+		{Type: WORD_TOKEN, Value: "two", RawValue: "two", Index: 4, EndIndex: 7, ByteIndex: 4, ByteEndIndex: 7, Leading: " ", State: IN_WORD_STATE},
+		{Type: WORD_TOKEN, Value: "three four", RawValue: "\"three four\"", Index: 8, EndIndex: 20, ByteIndex: 8, ByteEndIndex: 20, Leading: " ", Quote: `"`, State: IN_WORD_STATE},
+		{Type: WORD_TOKEN, Value: "five \"six\"", RawValue: "\"five \\\"six\\\"\"", Index: 21, EndIndex: 35, ByteIndex: 21, ByteEndIndex: 35, Leading: " ", Quote: `"`, State: IN_WORD_STATE},
+		{Type: WORD_TOKEN, Value: "seven#eight", RawValue: "seven#eight", Index: 36, EndIndex: 47, ByteIndex: 36, ByteEndIndex: 47, Leading: " ", State: IN_WORD_STATE},
+		{Type: COMMENT_TOKEN, Value: " nine # ten", RawValue: "# nine # ten", Index: 48, EndIndex: 60, ByteIndex: 48, ByteEndIndex: 60, Leading: " ", State: START_STATE},
+		{Type: WORD_TOKEN, Value: "eleven", RawValue: "eleven", Index: 62, EndIndex: 68, ByteIndex: 62, ByteEndIndex: 68, Leading: "\n ", State: IN_WORD_STATE},
+		{Type: WORD_TOKEN, Value: "twelve\\", RawValue: "'twelve\\'", Index: 69, EndIndex: 78, ByteIndex: 69, ByteEndIndex: 78, Leading: " ", Quote: "'", State: IN_WORD_STATE},
+		{Type: WORD_TOKEN, Value: "thirteen", RawValue: "thirteen", Index: 79, EndIndex: 87, ByteIndex: 79, ByteEndIndex: 87, Leading: " ", State: IN_WORD_STATE},
+		{Type: WORDBREAK_TOKEN, Value: "=", RawValue: "=", Index: 87, EndIndex: 88, ByteIndex: 87, ByteEndIndex: 88, State: WORDBREAK_STATE},
+		{Type: WORD_TOKEN, Value: "13", RawValue: "13", Index: 88, EndIndex: 90, ByteIndex: 88, ByteEndIndex: 90, State: IN_WORD_STATE},
+		{Type: WORD_TOKEN, Value: "fourteen/14", RawValue: "fourteen/14", Index: 91, EndIndex: 102, ByteIndex: 91, ByteEndIndex: 102, Leading: " ", State: IN_WORD_STATE},
+		{Type: WORDBREAK_TOKEN, Value: "|", RawValue: "|", Index: 103, EndIndex: 104, ByteIndex: 103, ByteEndIndex: 104, Leading: " ", State: WORDBREAK_STATE, WordbreakType: WORDBREAK_PIPE},
+		{Type: WORDBREAK_TOKEN, Value: "||", RawValue: "||", Index: 105, EndIndex: 107, ByteIndex: 105, ByteEndIndex: 107, Leading: " ", State: WORDBREAK_STATE, WordbreakType: WORDBREAK_LIST_OR},
+		{Type: WORDBREAK_TOKEN, Value: "|", RawValue: "|", Index: 108, EndIndex: 109, ByteIndex: 108, ByteEndIndex: 109, Leading: " ", State: WORDBREAK_STATE, WordbreakType: WORDBREAK_PIPE},
+		{Type: WORD_TOKEN, Value: "after", RawValue: "after", Index: 109, EndIndex: 114, ByteIndex: 109, ByteEndIndex: 114, State: IN_WORD_STATE},
+		{Type: WORD_TOKEN, Value: "before", RawValue: "before", Index: 115, EndIndex: 121, ByteIndex: 115, ByteEndIndex: 121, Leading: " ", State: IN_WORD_STATE},
+		{Type: WORDBREAK_TOKEN, Value: "|", RawValue: "|", Index: 121, EndIndex: 122, ByteIndex: 121, ByteEndIndex: 122, State: WORDBREAK_STATE, WordbreakType: WORDBREAK_PIPE},
+		{Type: WORDBREAK_TOKEN, Value: "&", RawValue: "&", Index: 123, EndIndex: 124, ByteIndex: 123, ByteEndIndex: 124, Leading: " ", State: WORDBREAK_STATE, WordbreakType: WORDBREAK_LIST_ASYNC},
+		{Type: WORDBREAK_TOKEN, Value: ";", RawValue: ";", Index: 125, EndIndex: 126, ByteIndex: 125, ByteEndIndex: 126, Leading: " ", State: WORDBREAK_STATE, WordbreakType: WORDBREAK_LIST_SEQUENTIAL},
+		{Type: WORD_TOKEN, Value: "", RawValue: "", Index: 126, EndIndex: 126, ByteIndex: 126, ByteEndIndex: 126, State: START_STATE},
+	}
+
+	tokenizer := NewTokenizer(testInput)
 	for i, want := range expectedTokens {
 		got, err := tokenizer.Next()
 		if err != nil {
@@ -89,7 +103,7 @@ func TestLexer(t *testing.T) {
 	testInput := strings.NewReader(testString)
 	expectedStrings := []string{"one", "two", "three four", "five \"six\"", "seven#eight", "eleven", "twelve\\", "thirteen", "=", "13", "fourteen/14"}
 
-	lexer := newLexer(testInput)
+	lexer := NewLexer(testInput)
 	for i, want := range expectedStrings {
 		got, err := lexer.Next()
 		if err != nil {
@@ -116,3 +130,2220 @@ func TestSplit(t *testing.T) {
 		}
 	}
 }
+
+func TestHeredoc(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []string
+		body  Token
+	}{
+		{"cat <<EOF\nline one\nEOF\n ls", []string{"cat", "<<", "EOF", "line one\n", "ls"}, Token{Type: HEREDOC_TOKEN, Value: "line one\n", RawValue: "line one\nEOF", Index: 10, EndIndex: 22, ByteIndex: 10, ByteEndIndex: 22, Leading: "\n", State: START_STATE}},
+		{"cat <<'EOF'\na b\nEOF", []string{"cat", "<<", "EOF", "a b\n"}, Token{Type: HEREDOC_TOKEN, Value: "a b\n", RawValue: "a b\nEOF", Index: 12, EndIndex: 19, ByteIndex: 12, ByteEndIndex: 19, Leading: "\n", State: START_STATE}},
+		{"cat <<-EOF\n\ta\n\t\tb\n\tEOF\n", []string{"cat", "<<-", "EOF", "a\nb\n", ""}, Token{Type: HEREDOC_TOKEN, Value: "a\nb\n", RawValue: "\ta\n\t\tb\n\tEOF", Index: 11, EndIndex: 22, ByteIndex: 11, ByteEndIndex: 22, Leading: "\n", State: START_STATE}},
+		{"cat <<EOF | grep a\nline\n", []string{"cat", "<<", "EOF", "|", "grep", "a", "line\n"}, Token{Type: HEREDOC_TOKEN, Value: "line\n", RawValue: "line\n", Index: 19, EndIndex: 24, ByteIndex: 19, ByteEndIndex: 24, Leading: "\n", State: HEREDOC_STATE}},
+		{"cat <<A <<B\na\nA\nb\nB", []string{"cat", "<<", "A", "<<", "B", "a\n", "b\n"}, Token{Type: HEREDOC_TOKEN, Value: "b\n", RawValue: "b\nB", Index: 16, EndIndex: 19, ByteIndex: 16, ByteEndIndex: 19, Leading: "\n", State: START_STATE}},
+		{"cat <<EOF # c\na\nEOF", []string{"cat", "<<", "EOF", "a\n"}, Token{Type: HEREDOC_TOKEN, Value: "a\n", RawValue: "a\nEOF", Index: 14, EndIndex: 19, ByteIndex: 14, ByteEndIndex: 19, Leading: " # c\n", State: START_STATE}},
+	}
+	for _, test := range tests {
+		tokens, err := Split(test.input)
+		if err != nil {
+			t.Error(err)
+		}
+		if got := tokens.Strings(); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Split(%q) -> %#v. Want: %#v", test.input, got, test.want)
+		}
+		var body Token
+		for _, token := range tokens {
+			if token.Type == HEREDOC_TOKEN {
+				body = token
+			}
+		}
+		if !body.Equal(&test.body) {
+			t.Errorf("Split(%q) heredoc \nGot : %#v\nWant: %#v", test.input, body, test.body)
+		}
+	}
+}
+
+func TestANSICQuoting(t *testing.T) {
+	tests := map[string]Token{
+		`$'foo\nbar'`:       {Type: WORD_TOKEN, Value: "foo\nbar", RawValue: `$'foo\nbar'`, EndIndex: 11, ByteIndex: 0, ByteEndIndex: 11, Quote: "$'", State: IN_WORD_STATE},
+		`a$'\t'b`:           {Type: WORD_TOKEN, Value: "a\tb", RawValue: `a$'\t'b`, EndIndex: 7, ByteIndex: 0, ByteEndIndex: 7, Quote: "$'", State: IN_WORD_STATE, WordbreakIndex: 1},
+		`$'\x41\u00e9\'\\'`: {Type: WORD_TOKEN, Value: "A\u00e9'\\", RawValue: `$'\x41\u00e9\'\\'`, EndIndex: 17, ByteIndex: 0, ByteEndIndex: 17, Quote: "$'", State: IN_WORD_STATE},
+		`$'\101\0'`:         {Type: WORD_TOKEN, Value: "A\x00", RawValue: `$'\101\0'`, EndIndex: 9, ByteIndex: 0, ByteEndIndex: 9, Quote: "$'", State: IN_WORD_STATE},
+		`$'\xg\q'`:          {Type: WORD_TOKEN, Value: `\xg\q`, RawValue: `$'\xg\q'`, EndIndex: 8, ByteIndex: 0, ByteEndIndex: 8, Quote: "$'", State: IN_WORD_STATE},
+		`$'foo bar`:         {Type: WORD_TOKEN, Value: "foo bar", RawValue: `$'foo bar`, EndIndex: 9, ByteIndex: 0, ByteEndIndex: 9, Quote: "$'", Unterminated: true, State: ANSI_C_QUOTING_STATE},
+		`$'foo\`:            {Type: WORD_TOKEN, Value: "foo", RawValue: `$'foo\`, EndIndex: 6, ByteIndex: 0, ByteEndIndex: 6, Quote: "$'", Unterminated: true, State: ESCAPING_ANSI_C_STATE},
+		`$HOME`:             {Type: WORD_TOKEN, Value: "$HOME", RawValue: `$HOME`, EndIndex: 5, ByteIndex: 0, ByteEndIndex: 5, State: IN_WORD_STATE, Expansions: []Expansion{{Name: "HOME", Start: 0, End: 5}}},
+		`"$'foo'"`:          {Type: WORD_TOKEN, Value: "$'foo'", RawValue: `"$'foo'"`, EndIndex: 8, ByteIndex: 0, ByteEndIndex: 8, Quote: `"`, State: IN_WORD_STATE},
+		`$`:                 {Type: WORD_TOKEN, Value: "$", RawValue: `$`, EndIndex: 1, ByteIndex: 0, ByteEndIndex: 1, State: IN_WORD_STATE, Expansions: []Expansion{{Name: "", Start: 0, End: 1}}},
+	}
+	for s, want := range tests {
+		tokens, err := Split(s)
+		if err != nil {
+			t.Error(err)
+		}
+		if len(tokens) != 1 || !tokens[0].Equal(&want) {
+			t.Errorf("Split(%q) \nGot : %#v\nWant: %#v", s, tokens, want)
+		}
+	}
+}
+
+func TestLocaleQuoting(t *testing.T) {
+	tests := map[string]Token{
+		`$"foo bar"`:  {Type: WORD_TOKEN, Value: "foo bar", RawValue: `$"foo bar"`, EndIndex: 10, ByteIndex: 0, ByteEndIndex: 10, Quote: "$\"", State: IN_WORD_STATE},
+		`a$"b \"c\""`: {Type: WORD_TOKEN, Value: `ab "c"`, RawValue: `a$"b \"c\""`, EndIndex: 11, ByteIndex: 0, ByteEndIndex: 11, Quote: "$\"", State: IN_WORD_STATE, WordbreakIndex: 1},
+		`$"foo`:       {Type: WORD_TOKEN, Value: "foo", RawValue: `$"foo`, EndIndex: 5, ByteIndex: 0, ByteEndIndex: 5, Quote: "$\"", Unterminated: true, State: QUOTING_ESCAPING_STATE},
+	}
+	for s, want := range tests {
+		tokens, err := Split(s)
+		if err != nil {
+			t.Error(err)
+		}
+		if len(tokens) != 1 || !tokens[0].Equal(&want) {
+			t.Errorf("Split(%q) \nGot : %#v\nWant: %#v", s, tokens, want)
+		}
+
+		joined := Join(tokens.Strings())
+		rejoined, err := Split(joined)
+		if err != nil {
+			t.Error(err)
+		}
+		if got := rejoined.Strings(); !reflect.DeepEqual(got, tokens.Strings()) {
+			t.Errorf("Split(Join(%q)) -> %#v. Want: %#v", s, got, tokens.Strings())
+		}
+	}
+}
+
+func TestSubstitution(t *testing.T) {
+	tests := map[string][]string{
+		`echo $(ls | grep foo) bar`:       {"echo", "$(ls | grep foo)", "bar"},
+		`echo "$(ls -la | grep foo)" bar`: {"echo", "$(ls -la | grep foo)", "bar"},
+		`echo a$(echo ")" '(')b c`:        {"echo", `a$(echo ")" '(')b`, "c"},
+		`echo $(echo $(echo (a)) \)) b`:   {"echo", `$(echo $(echo (a)) \))`, "b"},
+		`echo "$(echo "a b")" c`:          {"echo", `$(echo "a b")`, "c"},
+		`echo $(ls | grep`:                {"echo", "$(ls | grep"},
+	}
+	for s, want := range tests {
+		tokens, err := Split(s)
+		if err != nil {
+			t.Error(err)
+		}
+		if got := tokens.Strings(); !reflect.DeepEqual(got, want) {
+			t.Errorf("Split(%q) -> %#v. Want: %#v", s, got, want)
+		}
+		if last := tokens[len(tokens)-1]; strings.HasSuffix(s, "grep") != (last.State == SUBSTITUTION_STATE) {
+			t.Errorf("Split(%q) -> unexpected state %v", s, last.State)
+		}
+		if !tokens[1].HasSubstitution {
+			t.Errorf("Split(%q)[1] -> missing substitution", s)
+		}
+		if tokens[0].HasSubstitution {
+			t.Errorf("Split(%q)[0] -> unexpected substitution", s)
+		}
+	}
+}
+
+func TestBackquotes(t *testing.T) {
+	tests := map[string]Token{
+		"`cmd arg`":   {Type: WORD_TOKEN, Value: "`cmd arg`", RawValue: "`cmd arg`", EndIndex: 9, ByteIndex: 0, ByteEndIndex: 9, Quote: "`", State: IN_WORD_STATE, HasSubstitution: true},
+		"a`b \\` c`d": {Type: WORD_TOKEN, Value: "a`b \\` c`d", RawValue: "a`b \\` c`d", EndIndex: 10, ByteIndex: 0, ByteEndIndex: 10, Quote: "`", State: IN_WORD_STATE, HasSubstitution: true},
+		"\"`a`\"":     {Type: WORD_TOKEN, Value: "`a`", RawValue: "\"`a`\"", EndIndex: 5, ByteIndex: 0, ByteEndIndex: 5, Quote: `"`, State: IN_WORD_STATE},
+		"`cmd arg":    {Type: WORD_TOKEN, Value: "`cmd arg", RawValue: "`cmd arg", EndIndex: 8, ByteIndex: 0, ByteEndIndex: 8, Quote: "`", Unterminated: true, State: BACKQUOTING_STATE, HasSubstitution: true},
+		"`cmd arg\\":  {Type: WORD_TOKEN, Value: "`cmd arg\\", RawValue: "`cmd arg\\", EndIndex: 9, ByteIndex: 0, ByteEndIndex: 9, Quote: "`", Unterminated: true, State: ESCAPING_BACKQUOTED_STATE, HasSubstitution: true},
+	}
+	for s, want := range tests {
+		tokens, err := Split(s)
+		if err != nil {
+			t.Error(err)
+		}
+		if len(tokens) != 1 || !tokens[0].Equal(&want) {
+			t.Errorf("Split(%q) \nGot : %#v\nWant: %#v", s, tokens, want)
+		}
+	}
+
+	words := []string{"echo", "`cmd arg`", "`a\\`b`"}
+	tokens, err := Split(Join(words))
+	if err != nil {
+		t.Error(err)
+	}
+	if got := tokens.Strings(); !reflect.DeepEqual(got, words) {
+		t.Errorf("Split(Join(%#v)) -> %#v", words, got)
+	}
+}
+
+func TestProcessSubstitution(t *testing.T) {
+	tests := map[string][]string{
+		`diff <(sort a) <(sort b)`:          {"diff", "<(sort a)", "<(sort b)"},
+		`tee >(wc -l) foo`:                  {"tee", ">(wc -l)", "foo"},
+		`diff <(sort <(ls | grep ")")) b`:   {"diff", `<(sort <(ls | grep ")"))`, "b"},
+		`cat < (a)`:                         {"cat", "<", "(", "a)"},
+		`diff <(sort a) <(sort b | grep fo`: {"diff", "<(sort a)", "<(sort b | grep fo"},
+	}
+	for s, want := range tests {
+		tokens, err := Split(s)
+		if err != nil {
+			t.Error(err)
+		}
+		if got := tokens.Strings(); !reflect.DeepEqual(got, want) {
+			t.Errorf("Split(%q) -> %#v. Want: %#v", s, got, want)
+		}
+		if got := tokens.CurrentPipeline().Strings(); !reflect.DeepEqual(got, want) {
+			t.Errorf("Split(%q).CurrentPipeline() -> %#v. Want: %#v", s, got, want)
+		}
+	}
+
+	tokens, err := Split(`diff <(sort a) <(sort b | grep fo`)
+	if err != nil {
+		t.Error(err)
+	}
+	if last := tokens.CurrentToken(); last.State != SUBSTITUTION_STATE || last.Index != 15 {
+		t.Errorf("unexpected current token %#v", last)
+	}
+}
+
+func TestArithmeticExpansion(t *testing.T) {
+	tests := map[string]Token{
+		`$((1 + 2))`:            {Type: WORD_TOKEN, Value: `$((1 + 2))`, RawValue: `$((1 + 2))`, EndIndex: 10, ByteIndex: 0, ByteEndIndex: 10, State: IN_WORD_STATE},
+		`$(( (1+2) * 3 ))`:      {Type: WORD_TOKEN, Value: `$(( (1+2) * 3 ))`, RawValue: `$(( (1+2) * 3 ))`, EndIndex: 16, ByteIndex: 0, ByteEndIndex: 16, State: IN_WORD_STATE},
+		`x$(( $(echo 1) + 2 ))`: {Type: WORD_TOKEN, Value: `x$(( $(echo 1) + 2 ))`, RawValue: `x$(( $(echo 1) + 2 ))`, EndIndex: 21, ByteIndex: 0, ByteEndIndex: 21, State: IN_WORD_STATE},
+		`$(( (1+2) * `:          {Type: WORD_TOKEN, Value: `$(( (1+2) * `, RawValue: `$(( (1+2) * `, EndIndex: 12, ByteIndex: 0, ByteEndIndex: 12, State: ARITHMETIC_STATE, Unterminated: true},
+		`$( (1+2) * `:           {Type: WORD_TOKEN, Value: `$( (1+2) * `, RawValue: `$( (1+2) * `, EndIndex: 11, ByteIndex: 0, ByteEndIndex: 11, State: SUBSTITUTION_STATE, HasSubstitution: true, Unterminated: true},
+	}
+	for s, want := range tests {
+		tokens, err := Split(s)
+		if err != nil {
+			t.Error(err)
+		}
+		if len(tokens) != 1 || !tokens[0].Equal(&want) {
+			t.Errorf("Split(%q) \nGot : %#v\nWant: %#v", s, tokens, want)
+		}
+	}
+
+	s := "echo $((1 + 2)) foo"
+	tokens, err := Split(s)
+	if err != nil {
+		t.Error(err)
+	}
+	if got, want := tokens.Strings(), []string{"echo", "$((1 + 2))", "foo"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Split(%q) -> %#v. Want: %#v", s, got, want)
+	}
+}
+
+func TestExpansions(t *testing.T) {
+	tests := map[string][]Expansion{
+		`$HOME/bin`:             {{Name: "HOME", Start: 0, End: 5}},
+		`a${HOME}b$USER_1`:      {{Name: "HOME", Start: 1, End: 8}, {Name: "USER_1", Start: 9, End: 16}},
+		`"$HOME $PATH"`:         {{Name: "HOME", Start: 0, End: 5}, {Name: "PATH", Start: 6, End: 11}},
+		`${VAR:-${OTHER} x}/`:   {{Name: "VAR", Start: 0, End: 18}},
+		`$`:                     {{Name: "", Start: 0, End: 1}},
+		`${PA`:                  {{Name: "PA", Start: 0, End: 4}},
+		`'$HOME'`:               nil,
+		`\$HOME`:                nil,
+		`$1 $? $'a' $"b" $(c)`:  nil,
+		`"\$HOME" a$-b x$ '$y'`: nil,
+		`$$HOME $1PATH`:         nil, // special parameters
+	}
+	for s, want := range tests {
+		tokens, err := Split(s)
+		if err != nil {
+			t.Error(err)
+		}
+		var got []Expansion
+		for _, token := range tokens {
+			got = append(got, token.Expansions...)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Split(%q) expansions -> %#v. Want: %#v", s, got, want)
+		}
+	}
+}
+
+func TestVarExpansion(t *testing.T) {
+	env := map[string]string{"PROJECTS": "/home/user/projects", "EMPTY": "", "SPACE": "a b", "UNICODE": "ä"}
+	lookup := func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+	tests := map[string][]string{
+		`cd $PROJECTS/`:          {"cd", "/home/user/projects/"},
+		`cd ${PROJECTS}/a`:       {"cd", "/home/user/projects/a"},
+		`"$SPACE" $SPACE`:        {"a b", "a b"},
+		`x$EMPTY$UNKNOWN-y`:      {"x-y"},
+		`$UNICODE$UNICODE`:       {"ää"},
+		`'$PROJECTS' \$PROJECTS`: {"$PROJECTS", "$PROJECTS"},
+		`${PROJECTS:-x}/a`:       {"${PROJECTS:-x}/a"},
+		`${A${B}}x`:              {"${A${B}}x"},
+		`$1 $? $ ${} ${PROJ`:     {"$1", "$?", "$", "${}", "${PROJ"},
+		`"$(echo $PROJECTS)"`:    {"$(echo $PROJECTS)"},
+	}
+	for s, want := range tests {
+		tokens, err := Split(s, WithVarExpansion(lookup))
+		if err != nil {
+			t.Error(err)
+		}
+		if got := tokens.Strings(); !reflect.DeepEqual(got, want) {
+			t.Errorf("Split(%q) -> %#v. Want: %#v", s, got, want)
+		}
+		if got := tokens.Reconstruct(); got != s {
+			t.Errorf("Split(%q).Reconstruct() -> %q", s, got)
+		}
+	}
+
+	tokens, err := Split(`a$UNICODE${PROJECTS}b`, WithVarExpansion(lookup))
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := tokens[0]
+	if want := []Expansion{{Name: "UNICODE", Start: 1, End: 3}, {Name: "PROJECTS", Start: 3, End: 22}}; !reflect.DeepEqual(token.Expansions, want) {
+		t.Errorf("Expansions -> %#v. Want: %#v", token.Expansions, want)
+	}
+	if got, want := []int{token.RawOffset(0), token.RawOffset(1), token.RawOffset(2), token.RawOffset(21)}, []int{0, 1, 9, 20}; !reflect.DeepEqual(got, want) {
+		t.Errorf("RawOffset -> %v. Want: %v", got, want)
+	}
+
+	tokens, err = Split(`$PROJECTS/$UNKNOWN`, WithVarExpansion(lookup), WithKeepUnknownVars(true))
+	if err != nil {
+		t.Error(err)
+	}
+	if got, want := tokens.Strings(), []string{"/home/user/projects/$UNKNOWN"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Split with WithKeepUnknownVars -> %#v. Want: %#v", got, want)
+	}
+
+	tokens, err = Split(`cd $PROJECTS/`, WithVarExpansion(lookup), WithRawWords(true))
+	if err != nil {
+		t.Error(err)
+	}
+	if got, want := tokens.Strings(), []string{"cd", "$PROJECTS/"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Split with WithRawWords -> %#v. Want: %#v", got, want)
+	}
+}
+
+func TestTildeExpandable(t *testing.T) {
+	tests := map[string]bool{
+		`~`:       true,
+		`~/x`:     true,
+		`~user`:   true,
+		`~user/x`: true,
+		`"~"`:     false,
+		`'~'foo`:  false,
+		`\~foo`:   false,
+		`fo~o`:    false,
+		`$HOME`:   false,
+	}
+	for s, want := range tests {
+		tokens, err := Split(s)
+		if err != nil {
+			t.Error(err)
+		}
+		if got := tokens[0].TildeExpandable; got != want {
+			t.Errorf("Split(%q)[0].TildeExpandable -> %v. Want: %v", s, got, want)
+		}
+	}
+}
+
+func TestTildeExpansion(t *testing.T) {
+	homes := map[string]string{"": "/home/me", "user": "/home/user", "ü": "/home/ü"}
+	lookup := func(user string) (string, bool) {
+		home, ok := homes[user]
+		return home, ok
+	}
+	tests := map[string][]string{
+		`ls ~/Doc`:             {"ls", "/home/me/Doc"},
+		`~ ~user ~user/x`:      {"/home/me", "/home/user", "/home/user/x"},
+		`~ü/x`:                 {"/home/ü/x"},
+		`~unknown/x ~+`:        {"~unknown/x", "~+"},
+		`"~" '~'/x \~ ~"user"`: {"~", "~/x", "~", "~user"},
+		`a~ a/~ x=~`:           {"a~", "a/~", "x", "=", "/home/me"}, // `=` is a wordbreak like in bash assignments
+		`~/"a b" ~/$HOME`:      {"/home/me/a b", "/home/me/$HOME"},
+		`~$USER ~\/x`:          {"~$USER", "~/x"},
+	}
+	for s, want := range tests {
+		tokens, err := Split(s, WithTildeExpansion(lookup))
+		if err != nil {
+			t.Error(err)
+		}
+		if got := tokens.Strings(); !reflect.DeepEqual(got, want) {
+			t.Errorf("Split(%q) -> %#v. Want: %#v", s, got, want)
+		}
+		if got := tokens.Reconstruct(); got != s {
+			t.Errorf("Split(%q).Reconstruct() -> %q", s, got)
+		}
+	}
+
+	tokens, err := Split(`~/"x"$HOME`, WithTildeExpansion(lookup))
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := tokens[0]
+	if want := []Expansion{{Name: "HOME", Start: 10, End: 15}}; !reflect.DeepEqual(token.Expansions, want) {
+		t.Errorf("Expansions -> %#v. Want: %#v", token.Expansions, want)
+	}
+	if got, want := []int{token.RawOffset(0), token.RawOffset(7), token.RawOffset(8), token.RawOffset(9), token.RawOffset(10)}, []int{0, 0, 1, 3, 5}; !reflect.DeepEqual(got, want) {
+		t.Errorf("RawOffset -> %v. Want: %v", got, want)
+	}
+}
+
+func TestGlob(t *testing.T) {
+	tests := map[string]bool{
+		`*.go`:    true,
+		`foo[ab]`: true,
+		`fo?`:     true,
+		`"*"`:     false,
+		`'*'.go`:  false,
+		`\*`:      false,
+		`"$*" $?`: false,
+		`foo.go`:  false,
+		`"a"*`:    true,
+	}
+	for s, want := range tests {
+		tokens, err := Split(s)
+		if err != nil {
+			t.Error(err)
+		}
+		if got := tokens[0].HasGlob; got != want {
+			t.Errorf("Split(%q)[0].HasGlob -> %v. Want: %v", s, got, want)
+		}
+	}
+}
+
+func TestHistoryExpansion(t *testing.T) {
+	tests := map[string]bool{
+		`!!`:      true,
+		`!$`:      true,
+		`!git`:    true,
+		`!-2:p`:   true,
+		`!`:       false,
+		`! true`:  false,
+		`!=`:      false,
+		`\!git`:   false,
+		`'!'git`:  false,
+		`"!git"`:  false,
+		`foo!bar`: false,
+	}
+	for s, want := range tests {
+		tokens, err := Split(s, WithHistoryExpansion(true))
+		if err != nil {
+			t.Error(err)
+		}
+		if got := tokens[0].HistoryExpansion; got != want {
+			t.Errorf("Split(%q)[0].HistoryExpansion -> %v. Want: %v", s, got, want)
+		}
+
+		if tokens, _ := Split(s); tokens[0].HistoryExpansion {
+			t.Errorf("Split(%q)[0].HistoryExpansion -> true without WithHistoryExpansion", s)
+		}
+	}
+}
+
+func TestStrict(t *testing.T) {
+	tests := map[string]error{
+		`echo "foo`:              &UnclosedQuoteError{Index: 5, Quote: '"'},
+		`echo 'foo`:              &UnclosedQuoteError{Index: 5, Quote: '\''},
+		`echo a"b\`:              &UnclosedQuoteError{Index: 6, Quote: '"'},
+		"echo `foo":              &UnclosedQuoteError{Index: 5, Quote: '`'},
+		`echo $'foo`:             &UnclosedQuoteError{Index: 6, Quote: '\''},
+		`echo "a" 'b`:            &UnclosedQuoteError{Index: 9, Quote: '\''},
+		`echo foo\`:              &TrailingEscapeError{Index: 8},
+		`echo $(ls`:              &UnclosedSubstitutionError{Index: 5, QuoteIndex: -1},
+		`echo $((1 + (2`:         &UnclosedSubstitutionError{Index: 5, QuoteIndex: -1},
+		`cat <(ls`:               &UnclosedSubstitutionError{Index: 4, QuoteIndex: -1},
+		`echo "a$(ls`:            &UnclosedSubstitutionError{Index: 7, QuoteIndex: 5},
+		`echo "$(echo ')'`:       &UnclosedSubstitutionError{Index: 6, QuoteIndex: 5},
+		`echo "foo" 'bar' \ `:    nil,
+		`echo "foo` + "\n" + `"`: nil,
+	}
+	for s, want := range tests {
+		tokens, err := Split(s, WithStrict(true))
+		if !reflect.DeepEqual(errors.Unwrap(err), want) {
+			t.Errorf("Split(%q) -> %#v. Want: %#v", s, err, want)
+		}
+		if err != nil && tokens != nil {
+			t.Errorf("Split(%q) -> %#v. Want: nil", s, tokens)
+		}
+
+		if _, err := Split(s); err != nil {
+			t.Errorf("Split(%q) -> %v without WithStrict", s, err)
+		}
+	}
+
+	_, err := Split(`echo "foo`, WithStrict(true))
+	var unclosedQuoteError *UnclosedQuoteError
+	if !errors.As(err, &unclosedQuoteError) || unclosedQuoteError.Index != 5 {
+		t.Errorf("errors.As(%#v, *UnclosedQuoteError) failed", err)
+	}
+}
+
+func TestLexError(t *testing.T) {
+	tests := map[string]*LexError{
+		`echo "foo`: {Index: 5, State: QUOTING_ESCAPING_STATE, Msg: "unclosed quote", Err: &UnclosedQuoteError{Index: 5, Quote: '"'}},
+		`echo 'foo`: {Index: 5, State: QUOTING_STATE, Msg: "unclosed quote", Err: &UnclosedQuoteError{Index: 5, Quote: '\''}},
+		`echo foo\`: {Index: 8, State: ESCAPING_STATE, Msg: "trailing escape", Err: &TrailingEscapeError{Index: 8}},
+		`echo $(ls`: {Index: 5, State: SUBSTITUTION_STATE, Msg: "unclosed substitution", Err: &UnclosedSubstitutionError{Index: 5, QuoteIndex: -1}},
+	}
+	for s, want := range tests {
+		_, err := Split(s, WithStrict(true))
+		var lexError *LexError
+		if !errors.As(err, &lexError) || !reflect.DeepEqual(lexError, want) {
+			t.Errorf("Split(%q) -> %#v. Want: %#v", s, err, want)
+		}
+	}
+
+	readError := errors.New("read error")
+	tokenizer := NewTokenizer(io.MultiReader(strings.NewReader("echo a"), &errorReader{readError}))
+	var err error
+	for err == nil {
+		_, err = tokenizer.Next()
+	}
+	var lexError *LexError
+	if !errors.As(err, &lexError) || !errors.Is(err, readError) || lexError.Index != 6 {
+		t.Errorf("Tokenizer.Next() -> %#v. Want: LexError wrapping the read error", err)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	tests := []struct {
+		s    string
+		opts []Option
+		want error
+	}{
+		{"echo abcdef", []Option{WithMaxTokenLength(6)}, nil},
+		{"echo abcdefg", []Option{WithMaxTokenLength(6)}, &TokenTooLongError{Index: 5, Limit: 6}},
+		{"echo 'abc def", []Option{WithMaxTokenLength(6)}, &TokenTooLongError{Index: 5, Limit: 6}},
+		{"echo $(a b c d", []Option{WithMaxTokenLength(6)}, &TokenTooLongError{Index: 5, Limit: 6}},
+		{"echo          a", []Option{WithMaxTokenLength(6)}, &TokenTooLongError{Index: 4, Limit: 6}},
+		{"a b c", []Option{WithMaxTokens(3)}, nil},
+		{"a b c d", []Option{WithMaxTokens(3)}, &TooManyTokensError{Index: 6, Limit: 3}},
+		{"a b c ", []Option{WithMaxTokens(3)}, &TooManyTokensError{Index: 6, Limit: 3}},
+		{"a b c d", nil, nil},
+	}
+	for _, test := range tests {
+		_, err := Split(test.s, test.opts...)
+		if test.want == nil {
+			if err != nil {
+				t.Errorf("Split(%q) -> %v", test.s, err)
+			}
+			continue
+		}
+		var lexError *LexError
+		if !errors.As(err, &lexError) || !reflect.DeepEqual(lexError.Err, test.want) {
+			t.Errorf("Split(%q) -> %#v. Want: %#v", test.s, err, test.want)
+		}
+	}
+
+	input := strings.NewReader("'" + strings.Repeat("a", 1<<20))
+	tokenizer := NewTokenizer(input, WithMaxTokenLength(1024))
+	if _, err := tokenizer.Next(); err == nil || input.Len() < 1<<20-8192 {
+		t.Errorf("Tokenizer.Next() -> %v with %v bytes left. Want: early abort", err, input.Len())
+	}
+}
+
+func TestInvalidUTF8(t *testing.T) {
+	input := []byte("echo a\xc0\xafb \"\xe2\x82\" \x80 ok")
+
+	tokenize := func(policy InvalidUTF8Policy) (TokenSlice, error) {
+		tokens := make(TokenSlice, 0)
+		l := NewLexer(bytes.NewReader(input), WithInvalidUTF8(policy))
+		for {
+			token, err := l.Next()
+			if err == io.EOF {
+				return tokens, nil
+			}
+			if err != nil {
+				return tokens, err
+			}
+			tokens = append(tokens, *token)
+		}
+	}
+
+	tokens, err := tokenize(REPLACE_INVALID_UTF8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := tokens.Strings(), []string{"echo", "a\ufffd\ufffdb", "\ufffd\ufffd", "\ufffd", "ok"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("REPLACE_INVALID_UTF8 \nGot : %#v\nWant: %#v", got, want)
+	}
+	for index, token := range tokens {
+		if token.HasInvalidUTF8 != (index > 0 && index < 4) {
+			t.Errorf("HasInvalidUTF8 of %#v should be %v", token, !token.HasInvalidUTF8)
+		}
+	}
+	if got := tokens[4].Index; got != 17 {
+		t.Errorf("index after invalid UTF-8 should be 17: %v", got)
+	}
+	valid := tokens[1]
+	valid.HasInvalidUTF8 = false
+	if valid.Equal(&tokens[1]) {
+		t.Errorf("Equal should compare HasInvalidUTF8: %#v", tokens[1])
+	}
+
+	tokens, err = tokenize(PASS_THROUGH_INVALID_UTF8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := tokens.Strings(), []string{"echo", "a\xc0\xafb", "\xe2\x82", "\x80", "ok"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("PASS_THROUGH_INVALID_UTF8 \nGot : %#v\nWant: %#v", got, want)
+	}
+	if got := tokens[2].RawValue; got != "\"\xe2\x82\"" {
+		t.Errorf("PASS_THROUGH_INVALID_UTF8 raw value \nGot : %#v", got)
+	}
+	if !tokens[3].HasInvalidUTF8 {
+		t.Errorf("HasInvalidUTF8 should be set for passed through bytes: %#v", tokens[3])
+	}
+	if tokens, _ := Split("a\xc0", WithInvalidUTF8(PASS_THROUGH_INVALID_UTF8)); !reflect.DeepEqual(tokens.Strings(), []string{"a\xc0"}) {
+		t.Errorf("PASS_THROUGH_INVALID_UTF8 at EOF \nGot : %#v", tokens.Strings())
+	}
+
+	tokens, err = tokenize(ERROR_ON_INVALID_UTF8)
+	var lexError *LexError
+	var invalidUTF8Error *InvalidUTF8Error
+	switch {
+	case !errors.As(err, &lexError) || lexError.Index != 6 || lexError.State != IN_WORD_STATE:
+		t.Errorf("ERROR_ON_INVALID_UTF8 should return a LexError: %#v", err)
+	case !errors.As(err, &invalidUTF8Error) || invalidUTF8Error.Offset != 6:
+		t.Errorf("ERROR_ON_INVALID_UTF8 should wrap InvalidUTF8Error: %#v", err)
+	case !reflect.DeepEqual(tokens.Strings(), []string{"echo"}):
+		t.Errorf("ERROR_ON_INVALID_UTF8 should return preceding tokens: %#v", tokens.Strings())
+	}
+
+	for _, policy := range []InvalidUTF8Policy{REPLACE_INVALID_UTF8, PASS_THROUGH_INVALID_UTF8} {
+		tokenizer := NewTokenizerString("\x80", WithInvalidUTF8(policy))
+		if r, size, err := tokenizer.ReadRune(); r != utf8.RuneError || size != 1 || err != nil {
+			t.Errorf("ReadRune() with %v -> %q, %v, %v. Want: %q, 1, <nil>", policy, r, size, err, utf8.RuneError)
+		}
+	}
+
+	if _, err := Split("echo \u00e9 \ufffd", WithInvalidUTF8(ERROR_ON_INVALID_UTF8)); err != nil {
+		t.Errorf("valid UTF-8 (including U+FFFD) should not return an error: %v", err)
+	}
+}
+
+type errorReader struct{ err error }
+
+func (r *errorReader) Read(p []byte) (int, error) { return 0, r.err }
+
+func TestComments(t *testing.T) {
+	tokenizer := NewTokenizer(strings.NewReader("echo #foo"))
+	tokenizer.Next()
+	if token, _ := tokenizer.Next(); token.Type != COMMENT_TOKEN || token.Value != "foo" {
+		t.Errorf("Tokenizer.Next()[1] of %q -> %#v. Want: COMMENT_TOKEN", "echo #foo", token)
+	}
+
+	tests := map[string][]string{
+		"echo #foo":                {"echo", "#foo"},
+		"echo # foo":               {"echo", "#", "foo"},
+		"curl http://host/#frag":   {"curl", "http://host/#frag"},
+		"curl http://host/ #frag":  {"curl", "http://host/", "#frag"},
+		"echo '#'foo \\#bar":       {"echo", "#foo", "#bar"},
+		"echo ${#foo} #bar | wc #": {"echo", "${#foo}", "#bar", "|", "wc", "#"},
+	}
+	for s, want := range tests {
+		tokens, err := Split(s, WithComments(false))
+		if err != nil {
+			t.Error(err)
+		}
+		if got := tokens.Words().Strings(); !reflect.DeepEqual(got, want) {
+			t.Errorf("Split(%q) -> %#v. Want: %#v", s, got, want)
+		}
+	}
+
+	if tokens, _ := Split("curl http://host/ #frag"); !reflect.DeepEqual(tokens.Strings(), []string{"curl", "http", ":", "//host/"}) {
+		t.Errorf("Split(%q) -> %#v. Want comment to be skipped", "curl http://host/ #frag", tokens.Strings())
+	}
+
+	tests = map[string][]string{
+		"echo # c\n":   {"echo"},
+		"echo # c\r\n": {"echo"},
+		"echo # c\n ":  {"echo", ""}, // cursor on a new line
+		"echo # c\nls": {"echo", "ls"},
+	}
+	for s, want := range tests {
+		tokens, err := Split(s)
+		if err != nil {
+			t.Error(err)
+		}
+		if got := tokens.Strings(); !reflect.DeepEqual(got, want) {
+			t.Errorf("Split(%q) -> %#v. Want: %#v", s, got, want)
+		}
+	}
+}
+
+func TestCommentRunes(t *testing.T) {
+	tests := map[string][]string{
+		"echo #foo %bar":   {"echo", "#foo"},
+		"echo a%b % bar":   {"echo", "a%b"},
+		"echo '%'foo #bar": {"echo", "%foo", "#bar"},
+		"echo \\%foo #bar": {"echo", "%foo", "#bar"},
+	}
+	for s, want := range tests {
+		tokens, err := Split(s, WithCommentRunes("%"))
+		if err != nil {
+			t.Error(err)
+		}
+		if got := tokens.Strings(); !reflect.DeepEqual(got, want) {
+			t.Errorf("Split(%q) -> %#v. Want: %#v", s, got, want)
+		}
+	}
+
+	if tokens, _ := Split("echo #foo %bar", WithCommentRunes("#%")); !reflect.DeepEqual(tokens.Strings(), []string{"echo"}) {
+		t.Errorf("Split(%q) -> %#v. Want: %#v", "echo #foo %bar", tokens.Strings(), []string{"echo"})
+	}
+	if tokens, _ := Split("echo #foo", WithCommentRunes("")); !reflect.DeepEqual(tokens.Strings(), []string{"echo", "#foo"}) {
+		t.Errorf("Split(%q) -> %#v. Want: %#v", "echo #foo", tokens.Strings(), []string{"echo", "#foo"})
+	}
+
+	classifier := NewDefaultClassifier()
+	if tokens, _ := Split("echo #foo %bar", WithClassifier(classifier), WithCommentRunes("%")); !reflect.DeepEqual(tokens.Strings(), []string{"echo", "#foo"}) {
+		t.Errorf("Split(%q) -> %#v. Want: %#v", "echo #foo %bar", tokens.Strings(), []string{"echo", "#foo"})
+	}
+	if classifier.ClassifyRune('#') != COMMENT_RUNE_CLASS || classifier.ClassifyRune('%') != UNKNOWN_RUNE_CLASS {
+		t.Error("WithCommentRunes modified the classifier passed with WithClassifier")
+	}
+
+	classifier = NewDefaultClassifier()
+	classifier['%'] = COMMENT_RUNE_CLASS
+	if tokens, _ := Split("echo %foo", WithClassifier(classifier)); !reflect.DeepEqual(tokens.Strings(), []string{"echo"}) {
+		t.Errorf("Split(%q) -> %#v. Want: %#v", "echo %foo", tokens.Strings(), []string{"echo"})
+	}
+}
+
+func TestEscapeRunes(t *testing.T) {
+	tests := map[string][]string{
+		`C:\foo\bar.txt`:            {`C:\foo\bar.txt`},
+		`"C:\Program Files\foo" /S`: {`C:\Program Files\foo`, "/S"},
+		`dir C:\Program\ Files`:     {"dir", `C:\Program\`, "Files"},
+		`echo "a\" 'c\'`:            {"echo", `a\`, `c\`},
+	}
+	for s, want := range tests {
+		tokens, err := Split(s, WithEscapeRunes(""))
+		if err != nil {
+			t.Error(err)
+		}
+		if got := tokens.Words().Strings(); !reflect.DeepEqual(got, want) {
+			t.Errorf("Split(%q) -> %#v. Want: %#v", s, got, want)
+		}
+	}
+
+	joinTests := map[string][]string{
+		`"C:\Program Files\foo" /S`: {`C:\Program Files\foo`, "/S"},
+		`"" "a"'"'"b" "c'd"`:        {"", `a"b`, "c'd"},
+		`C:\foo`:                    {`C:\foo`},
+	}
+	for want, words := range joinTests {
+		joined := Join(words, WithEscapeRunes(""))
+		if joined != want {
+			t.Errorf("Join(%#v) -> %v. Want: %v", words, joined, want)
+		}
+		tokens, err := Split(joined, WithEscapeRunes(""))
+		if err != nil {
+			t.Error(err)
+		}
+		if got := tokens.Words().Strings(); !reflect.DeepEqual(got, words) {
+			t.Errorf("Split(Join(%#v)) -> %#v", words, got)
+		}
+	}
+
+	if joined := Join([]string{"a b"}); joined != `"a b"` {
+		t.Errorf("Join(%#v) -> %v. Want: %v", []string{"a b"}, joined, `"a b"`)
+	}
+}
+
+func TestQuoteRunes(t *testing.T) {
+	tests := []struct {
+		input string
+		opts  []Option
+		want  []string
+	}{
+		{"echo `a b` 'c d'", []Option{WithNonEscapingQuotes("`")}, []string{"echo", "a b", "'c", "d'"}},
+		{"echo `a 'b` 'c `d'", []Option{WithNonEscapingQuotes("'`")}, []string{"echo", "a 'b", "c `d"}},
+		{`echo "a \"b" ^a "b^`, []Option{WithEscapingQuotes(`"^`)}, []string{"echo", `a "b`, `a "b`}},
+		{`echo "a 'b" 'c "d'`, []Option{WithEscapingQuotes(`'"`), WithNonEscapingQuotes("")}, []string{"echo", "a 'b", `c "d`}},
+		{`echo 'a\'b' "c`, []Option{WithEscapingQuotes(`'`), WithNonEscapingQuotes("")}, []string{"echo", "a'b", `"c`}},
+		{`echo "a b" 'c d'`, []Option{WithEscapingQuotes(""), WithNonEscapingQuotes("")}, []string{"echo", `"a`, `b"`, "'c", "d'"}},
+	}
+	for _, test := range tests {
+		tokens, err := Split(test.input, test.opts...)
+		if err != nil {
+			t.Error(err)
+		}
+		if got := tokens.Words().Strings(); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Split(%q) -> %#v. Want: %#v", test.input, got, test.want)
+		}
+	}
+}
+
+func TestDialect(t *testing.T) {
+	tests := []struct {
+		input   string
+		dialect Dialect
+		want    []string
+	}{
+		{"echo #foo 'bar'", BASH_DIALECT, []string{"echo"}},
+		{"echo #foo 'bar'", ZSH_DIALECT, []string{"echo", "#foo", "bar"}},
+		{"git log --format=%H#%s", ZSH_DIALECT, []string{"git", "log", "--format", "=", "%H#%s"}},
+	}
+	for _, test := range tests {
+		tokens, err := Split(test.input, WithDialect(test.dialect))
+		if err != nil {
+			t.Error(err)
+		}
+		if got := tokens.Strings(); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Split(%q) -> %#v. Want: %#v", test.input, got, test.want)
+		}
+	}
+
+	if tokens, _ := Split("echo #foo", WithDialect(ZSH_DIALECT), WithComments(true)); !reflect.DeepEqual(tokens.Strings(), []string{"echo"}) {
+		t.Errorf("Split(%q) -> %#v. Want: %#v", "echo #foo", tokens.Strings(), []string{"echo"})
+	}
+}
+
+func TestPowerShellDialect(t *testing.T) {
+	tests := map[string][]string{
+		"Write-Host `\"hello world`\" -NoNewline": {"Write-Host", `"hello`, `world"`, "-NoNewline"},
+		`Write-Host "a""b" 'c''d' 'e"f'`:          {"Write-Host", `a"b`, "c'd", `e"f`},
+		"echo \"a `\"b`\" c\" 'd`e'":              {"echo", `a "b" c`, "d`e"},
+		`dir C:\Program\ Files`:                   {"dir", `C:\Program\`, "Files"},
+		`echo $'a' $"b" $env:PATH`:                {"echo", "$a", "$b", "$env:PATH"},
+		`echo "$(Get-Date) ok" | Out-Null`:        {"echo", "$(Get-Date) ok", "|", "Out-Null"},
+		`echo 'it''`:                              {"echo", "it'"},
+	}
+	for s, want := range tests {
+		tokens, err := Split(s, WithDialect(POWERSHELL_DIALECT))
+		if err != nil {
+			t.Error(err)
+		}
+		if got := tokens.Words().Strings(); !reflect.DeepEqual(got, want) {
+			t.Errorf("Split(%q) -> %#v. Want: %#v", s, got, want)
+		}
+	}
+
+	words := []string{"Write-Host", "hello world", "it's", `"quoted"`, "$env:PATH", "", "C:\\temp"}
+	joined := Join(words, WithDialect(POWERSHELL_DIALECT))
+	if want := `Write-Host 'hello world' 'it''s' '"quoted"' '$env:PATH' '' C:\temp`; joined != want {
+		t.Errorf("Join(%#v) -> %v. Want: %v", words, joined, want)
+	}
+	tokens, err := Split(joined, WithDialect(POWERSHELL_DIALECT))
+	if err != nil {
+		t.Error(err)
+	}
+	if got := tokens.Words().Strings(); !reflect.DeepEqual(got, words) {
+		t.Errorf("Split(Join(%#v)) -> %#v", words, got)
+	}
+}
+
+func TestCmdDialect(t *testing.T) {
+	tests := map[string][]string{
+		`dir "C:\Program Files" ^& echo done`: {"dir", `C:\Program Files`, "&", "echo", "done"},
+		`dir "C:\Program Files" & echo done`:  {"dir", `C:\Program Files`, "&", "echo", "done"},
+		`echo 'a b' ^"c "^d"`:                 {"echo", "'a", "b'", `"c`, "^d"},
+		`echo %PATH% $HOME #foo`:              {"echo", "%PATH%", "$HOME", "#foo"},
+		"echo `a` C:\\temp\\ ^":               {"echo", "`a`", `C:\temp\`, ""},
+	}
+	for s, want := range tests {
+		tokens, err := Split(s, WithDialect(CMD_DIALECT))
+		if err != nil {
+			t.Error(err)
+		}
+		if got := tokens.Words().Strings(); !reflect.DeepEqual(got, want) {
+			t.Errorf("Split(%q) -> %#v. Want: %#v", s, got, want)
+		}
+	}
+
+	tokens, err := Split(`dir "C:\Program Files" ^& echo done`, WithDialect(CMD_DIALECT))
+	if err != nil {
+		t.Error(err)
+	}
+	if got := tokens.CurrentPipeline().Words().Strings(); !reflect.DeepEqual(got, []string{"dir", `C:\Program Files`, "&", "echo", "done"}) {
+		t.Errorf("escaped `&` must not delimit the pipeline: %#v", got)
+	}
+
+	words := []string{"dir", `C:\Program Files`, "a&b", `say "hi"`, ""}
+	joined := Join(words, WithDialect(CMD_DIALECT))
+	if want := `dir "C:\Program Files" "a&b" "say "^""hi"^""" ""`; joined != want {
+		t.Errorf("Join(%#v) -> %v. Want: %v", words, joined, want)
+	}
+	tokens, err = Split(joined, WithDialect(CMD_DIALECT))
+	if err != nil {
+		t.Error(err)
+	}
+	if got := tokens.Words().Strings(); !reflect.DeepEqual(got, words) {
+		t.Errorf("Split(Join(%#v)) -> %#v", words, got)
+	}
+}
+
+func TestFishDialect(t *testing.T) {
+	// https://fishshell.com/docs/current/language.html#quotes
+	tests := map[string][]string{
+		`echo "price: \$5" ; ls`:        {"echo", "price: $5", ";", "ls"},
+		`echo 'It\'s' 'C:\\dir' 'a\nb'`: {"echo", "It's", `C:\dir`, `a\nb`},
+		`echo "a\nb" "\"q\" \\"`:        {"echo", `a\nb`, `"q" \`},
+		`echo a\nb \x41\t \$HOME\ x`:    {"echo", "a\nb", "A\t", "$HOME x"},
+		"echo `ls` $'a' $\"b\"":         {"echo", "`ls`", "$a", "$b"},
+		`echo a && echo b || echo c`:    {"echo", "a", "&&", "echo", "b", "||", "echo", "c"},
+		`echo foo # comment`:            {"echo", "foo"},
+		`echo (ls | grep foo) bar`:      {"echo", "(ls | grep foo)", "bar"},
+		`echo a(pwd)/b "(x)" \(y\)`:     {"echo", "a(pwd)/b", "(x)", "(y)"},
+		`echo (echo ')' (id)) | wc`:     {"echo", "(echo ')' (id))", "|", "wc"},
+	}
+	for s, want := range tests {
+		tokens, err := Split(s, WithDialect(FISH_DIALECT))
+		if err != nil {
+			t.Error(err)
+		}
+		if got := tokens.Words().Strings(); !reflect.DeepEqual(got, want) {
+			t.Errorf("Split(%q) -> %#v. Want: %#v", s, got, want)
+		}
+	}
+
+	tokens, err := Split(`echo "price: \$5" "$HOME"`, WithDialect(FISH_DIALECT))
+	if err != nil {
+		t.Error(err)
+	}
+	if tokens[1].Expansions != nil || len(tokens[2].Expansions) != 1 {
+		t.Errorf("Split(%q) expansions -> %#v, %#v", `echo "price: \$5" "$HOME"`, tokens[1].Expansions, tokens[2].Expansions)
+	}
+	if tokens, _ := Split("echo (ls", WithDialect(FISH_DIALECT)); !tokens[1].HasSubstitution || tokens.ClosingSuffix() != ")" {
+		t.Errorf("Split(%q) -> %#v. Want an unclosed substitution", "echo (ls", tokens)
+	}
+	if tokens, _ := Split(`echo a ; ls`, WithDialect(FISH_DIALECT)); tokens[2].WordbreakType != WORDBREAK_LIST_SEQUENTIAL {
+		t.Errorf("Split(%q)[2] -> %#v. Want: WORDBREAK_LIST_SEQUENTIAL", `echo a ; ls`, tokens[2])
+	}
+}
+
+func TestUnicodeSpaces(t *testing.T) {
+	s := "echo\u00a0foo\u2003bar baz"
+	tokens, err := Split(s)
+	if err != nil {
+		t.Error(err)
+	}
+	if got, want := tokens.Strings(), []string{"echo\u00a0foo\u2003bar", "baz"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Split(%q) -> %#v. Want: %#v", s, got, want)
+	}
+
+	tokens, err = Split(s, WithUnicodeSpaces(true))
+	if err != nil {
+		t.Error(err)
+	}
+	if got, want := tokens.Strings(), []string{"echo", "foo", "bar", "baz"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Split(%q) -> %#v. Want: %#v", s, got, want)
+	}
+	if got, want := []int{tokens[1].Index, tokens[2].Index, tokens[3].Index}, []int{5, 9, 13}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Split(%q) indexes -> %#v. Want: %#v", s, got, want)
+	}
+
+	tokens, err = Split("echo \"a\u00a0b\" c\u00a0", WithUnicodeSpaces(true))
+	if err != nil {
+		t.Error(err)
+	}
+	if got, want := tokens.Strings(), []string{"echo", "a\u00a0b", "c", ""}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Split(%q) -> %#v. Want: %#v", "echo \"a\u00a0b\" c\u00a0", got, want)
+	}
+}
+
+func TestSpaceTokens(t *testing.T) {
+	tests := []string{
+		"",
+		" ",
+		"echo  foo\tbar ",
+		testString,
+		"  echo \"a  b\" 'c' \\ d\\",
+		"echo foo # comment\n\tls -l #",
+		"cat <<EOF > out # comment\nbody\n  EOF\nEOF\n\necho done",
+		"cat <<-A <<B\n\ta\n\tA\nb\nB",
+		"(cd /tmp && ls) | { wc -l; } 2>&1",
+		"echo $(date) `id` $((1 + 2)) $'a\\tb' ${HOME} \"x",
+	}
+	for _, s := range tests {
+		tokenizer := NewTokenizer(strings.NewReader(s), WithSpaceTokens(true))
+		raw := ""
+		for {
+			token, err := tokenizer.Next()
+			if err != nil {
+				if err != io.EOF {
+					t.Error(err)
+				}
+				break
+			}
+			if token.Type == SPACE_TOKEN && ([]rune(s)[token.Index] != []rune(token.RawValue)[0] || token.Value != token.RawValue) {
+				t.Errorf("NewTokenizer(%q) space token -> %#v", s, token)
+			}
+			raw += token.RawValue
+		}
+		if raw != s {
+			t.Errorf("NewTokenizer(%q) raw values -> %q", s, raw)
+		}
+	}
+
+	tokenizer := NewTokenizer(strings.NewReader("a  b\t"), WithSpaceTokens(true))
+	expectedTokens := []*Token{
+		{Type: WORD_TOKEN, Value: "a", RawValue: "a", Index: 0, EndIndex: 1, ByteIndex: 0, ByteEndIndex: 1, State: IN_WORD_STATE},
+		{Type: SPACE_TOKEN, Value: "  ", RawValue: "  ", Index: 1, EndIndex: 3, ByteIndex: 1, ByteEndIndex: 3, State: SPACE_STATE},
+		{Type: WORD_TOKEN, Value: "b", RawValue: "b", Index: 3, EndIndex: 4, ByteIndex: 3, ByteEndIndex: 4, State: IN_WORD_STATE},
+		{Type: SPACE_TOKEN, Value: "\t", RawValue: "\t", Index: 4, EndIndex: 5, ByteIndex: 4, ByteEndIndex: 5, State: SPACE_STATE},
+		{Type: WORD_TOKEN, Value: "", RawValue: "", Index: 5, EndIndex: 5, ByteIndex: 5, ByteEndIndex: 5, State: START_STATE},
+	}
+	for i, want := range expectedTokens {
+		got, err := tokenizer.Next()
+		if err != nil {
+			t.Error(err)
+		}
+		if !got.Equal(want) {
+			t.Errorf("Tokenizer.Next()[%v] \nGot : %#v\nWant: %#v", i, got, want)
+		}
+	}
+
+	tokens, err := Split("echo  foo # bar\n", WithSpaceTokens(true))
+	if err != nil {
+		t.Error(err)
+	}
+	if got, want := tokens.Strings(), []string{"echo", "foo", ""}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Split(%q) -> %#v. Want: %#v", "echo  foo # bar\n", got, want)
+	}
+}
+
+func TestRawWords(t *testing.T) {
+	tests := map[string][]string{
+		`echo "a b" 'c'd \ e`:       {"echo", `"a b"`, `'c'd`, `\ e`},
+		"  echo\t$'\\t'  `id` #foo": {"echo", `$'\t'`, "`id`"},
+		`a=$(echo "x y")>out`:       {"a", "=", `$(echo "x y")`, ">", "out"},
+		`echo "unclosed \`:          {"echo", `"unclosed \`},
+		`echo `:                     {"echo", ""},
+	}
+	for s, want := range tests {
+		tokens, err := Split(s, WithRawWords(true))
+		if err != nil {
+			t.Error(err)
+		}
+		if got := tokens.Strings(); !reflect.DeepEqual(got, want) {
+			t.Errorf("Split(%q) -> %#v. Want: %#v", s, got, want)
+		}
+	}
+
+	tokens, err := Split(`echo a"b c`, WithRawWords(true))
+	if err != nil {
+		t.Error(err)
+	}
+	if got := tokens.WordbreakPrefix(); got != "a" {
+		t.Errorf("Split(%q).WordbreakPrefix() -> %q. Want: %q", `echo a"b c`, got, "a")
+	}
+}
+
+func TestEndIndex(t *testing.T) {
+	tests := map[string][][2]int{
+		"a|b":             {{0, 1}, {1, 2}, {2, 3}},
+		"echo \"x":        {{0, 4}, {5, 7}},
+		"a ":              {{0, 1}, {2, 2}},
+		"a\\":             {{0, 2}},
+		"ä ö|":            {{0, 1}, {2, 3}, {3, 4}, {4, 4}},
+		"echo $(a b":      {{0, 4}, {5, 10}},
+		"cat <<E\nb\nE\n": {{0, 3}, {4, 6}, {6, 7}, {8, 11}, {12, 12}},
+	}
+	for s, want := range tests {
+		tokens, err := Split(s)
+		if err != nil {
+			t.Error(err)
+		}
+		got := make([][2]int, 0)
+		for _, token := range tokens {
+			got = append(got, [2]int{token.Index, token.EndIndex})
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Split(%q) indexes -> %v. Want: %v", s, got, want)
+		}
+	}
+
+	tokens, err := Split("echo a=b")
+	if err != nil {
+		t.Error(err)
+	}
+	if words := tokens.Words(); words[1].Index != 5 || words[1].EndIndex != 8 {
+		t.Errorf("Split(%q).Words()[1] -> %#v", "echo a=b", words[1])
+	}
+}
+
+func TestQuote(t *testing.T) {
+	tests := map[string]string{
+		`foo`:       "",
+		`a\ b`:      "",
+		`'foo b`:    "'",
+		`"foo b`:    `"`,
+		`a"b c"d`:   `"`,
+		`'a'"b`:     `"`,
+		`"a"'b c'd`: "'",
+		`a"b"$'c`:   "$'",
+		`$"a b`:     `$"`,
+		"a`b":       "`",
+		`"a 'b' c`:  `"`,
+	}
+	for s, want := range tests {
+		tokens, err := Split(s)
+		if err != nil {
+			t.Error(err)
+		}
+		if got := tokens.CurrentToken().Quote; got != want {
+			t.Errorf("Split(%q).CurrentToken().Quote -> %q. Want: %q", s, got, want)
+		}
+	}
+}
+
+func TestUnterminated(t *testing.T) {
+	tests := map[string]bool{
+		`echo 'foo`:    true,
+		`echo "foo`:    true,
+		`echo foo\`:    true,
+		`echo "foo\`:   true,
+		`echo foo`:     false,
+		`echo 'foo'`:   false,
+		`echo "foo" `:  false,
+		`echo "a" 'b `: true,
+		`echo 'a' \ `:  false,
+	}
+	for s, want := range tests {
+		tokens, err := Split(s)
+		if err != nil {
+			t.Error(err)
+		}
+		if got := tokens.CurrentToken().Unterminated; got != want {
+			t.Errorf("Split(%q).CurrentToken().Unterminated -> %v. Want: %v", s, got, want)
+		}
+		for _, token := range tokens[:len(tokens)-1] {
+			if token.Unterminated {
+				t.Errorf("Split(%q) -> %#v. Want only the last token unterminated", s, token)
+			}
+		}
+	}
+}
+
+func TestStringer(t *testing.T) {
+	for tokenType := UNKNOWN_TOKEN; tokenType <= GROUP_TOKEN; tokenType++ {
+		if s := tokenType.String(); strings.HasPrefix(s, "TokenType(") || !strings.HasSuffix(s, "_TOKEN") {
+			t.Errorf("TokenType(%d).String() -> %v", int(tokenType), s)
+		}
+	}
+	for state := START_STATE; state <= SPACE_STATE; state++ {
+		if s := state.String(); strings.HasPrefix(s, "LexerState(") || !strings.HasSuffix(s, "_STATE") {
+			t.Errorf("LexerState(%d).String() -> %v", int(state), s)
+		}
+	}
+
+	if s := fmt.Sprintf("%v %v", WORD_TOKEN, QUOTING_STATE); s != "WORD_TOKEN QUOTING_STATE" {
+		t.Errorf("Sprintf -> %v", s)
+	}
+	if s := fmt.Sprintf("%v %v", TokenType(99), LexerState(99)); s != "TokenType(99) LexerState(99)" {
+		t.Errorf("Sprintf -> %v", s)
+	}
+}
+
+func TestUnmarshalJSON(t *testing.T) {
+	s := testString + ` "$HOME" ~/a* 2>&1 cat <<EOF` + "\nbody\nEOF\n(echo 'unclosed"
+	tokens, err := Split(s, WithHistoryExpansion(true))
+	if err != nil {
+		t.Error(err)
+	}
+	data, err := json.Marshal(tokens)
+	if err != nil {
+		t.Error(err)
+	}
+
+	var unmarshalled TokenSlice
+	if err := json.Unmarshal(data, &unmarshalled); err != nil {
+		t.Error(err)
+	}
+	if len(unmarshalled) != len(tokens) {
+		t.Fatalf("json.Unmarshal(%s) -> %v tokens. Want: %v", data, len(unmarshalled), len(tokens))
+	}
+	for index, token := range tokens {
+		if !token.Equal(&unmarshalled[index]) {
+			t.Errorf("json.Unmarshal()[%v] \nGot : %#v\nWant: %#v", index, unmarshalled[index], token)
+		}
+	}
+
+	var token Token
+	if err := json.Unmarshal([]byte(`{"Type":1,"State":"QUOTING_STATE","WordbreakType":"WORDBREAK_PIPE"}`), &token); err != nil {
+		t.Error(err)
+	}
+	if token.Type != WORD_TOKEN || token.State != QUOTING_STATE || token.WordbreakType != WORDBREAK_PIPE {
+		t.Errorf("json.Unmarshal() -> %#v", token)
+	}
+	if err := json.Unmarshal([]byte(`{"Type":"UNDEFINED_TOKEN"}`), &token); err == nil {
+		t.Error("json.Unmarshal() should fail for unknown token type")
+	}
+}
+
+func TestLineContinuation(t *testing.T) {
+	tests := []struct {
+		s       string
+		values  []string
+		indexes [][2]int
+	}{
+		{"foo\\\nbar", []string{"foobar"}, [][2]int{{0, 8}}},
+		{"foo \\\nbar", []string{"foo", "bar"}, [][2]int{{0, 3}, {6, 9}}},
+		{"foo \\\n bar", []string{"foo", "bar"}, [][2]int{{0, 3}, {7, 10}}},
+		{`"a\` + "\n" + `b"`, []string{"ab"}, [][2]int{{0, 6}}},
+		{`'a\` + "\n" + `b'`, []string{"a\\\nb"}, [][2]int{{0, 6}}},
+		{"a \\\n", []string{"a", ""}, [][2]int{{0, 1}, {4, 4}}},
+		{"a\\\n", []string{"a"}, [][2]int{{0, 3}}},
+	}
+	for _, test := range tests {
+		tokens, err := Split(test.s)
+		if err != nil {
+			t.Error(err)
+		}
+		indexes := make([][2]int, 0)
+		for _, token := range tokens {
+			indexes = append(indexes, [2]int{token.Index, token.EndIndex})
+		}
+		if got := tokens.Strings(); !reflect.DeepEqual(got, test.values) || !reflect.DeepEqual(indexes, test.indexes) {
+			t.Errorf("Split(%q) -> %#v %v. Want: %#v %v", test.s, got, indexes, test.values, test.indexes)
+		}
+		if got := tokens.Reconstruct(); got != test.s {
+			t.Errorf("Split(%q).Reconstruct() -> %q", test.s, got)
+		}
+	}
+
+	tokens, _ := Split("foo\\\nbar")
+	if offsets := tokens[0].RawOffsets; !reflect.DeepEqual(offsets, []int{0, 1, 2, 5, 6, 7}) {
+		t.Errorf("Split(%q)[0].RawOffsets -> %v", "foo\\\nbar", offsets)
+	}
+}
+
+func TestCRLF(t *testing.T) {
+	s := "echo a # first\r\nls -l\\\r\na | wc\r\ncat <<EOF # c\r\nbody\r\nEOF\r\necho \"x\\\r\ny\"\r\n"
+	tokens, err := Tokenize(s)
+	if err != nil {
+		t.Error(err)
+	}
+	if got := tokens.Reconstruct(); got != s {
+		t.Errorf("Tokenize(%q).Reconstruct() -> %q", s, got)
+	}
+
+	comments := make([]string, 0)
+	for _, token := range tokens {
+		if token.Type == COMMENT_TOKEN {
+			comments = append(comments, token.Value)
+		}
+	}
+	if want := []string{" first", " c"}; !reflect.DeepEqual(comments, want) {
+		t.Errorf("Tokenize(%q) comments -> %#v. Want: %#v", s, comments, want)
+	}
+
+	split, err := Split(s)
+	if err != nil {
+		t.Error(err)
+	}
+	statements := make([][]string, 0)
+	for _, statement := range split.Statements() {
+		statements = append(statements, statement.Strings())
+	}
+	want := [][]string{{"echo", "a"}, {"ls", "-la", "|", "wc"}, {"cat", "<<", "EOF", "body\r\n"}, {"echo", "xy"}, {""}}
+	if !reflect.DeepEqual(statements, want) {
+		t.Errorf("Split(%q).Statements() -> %#v. Want: %#v", s, statements, want)
+	}
+}
+
+func TestByteOrderMark(t *testing.T) {
+	tests := []struct {
+		s       string
+		values  []string
+		indexes [][2]int
+	}{
+		{"\uFEFFgit status", []string{"git", "status"}, [][2]int{{1, 4}, {5, 11}}},
+		{"\uFEFF", []string{""}, [][2]int{{1, 1}}},
+		{"\uFEFF'a b'", []string{"a b"}, [][2]int{{1, 6}}},
+		{"echo \uFEFFa", []string{"echo", "\uFEFFa"}, [][2]int{{0, 4}, {5, 7}}},
+		{"\uFEFF\uFEFFa", []string{"\uFEFFa"}, [][2]int{{1, 3}}},
+	}
+	for _, test := range tests {
+		tokens, err := Split(test.s)
+		if err != nil {
+			t.Error(err)
+		}
+		indexes := make([][2]int, 0)
+		for _, token := range tokens {
+			indexes = append(indexes, [2]int{token.Index, token.EndIndex})
+		}
+		if got := tokens.Strings(); !reflect.DeepEqual(got, test.values) || !reflect.DeepEqual(indexes, test.indexes) {
+			t.Errorf("Split(%q) -> %#v %v. Want: %#v %v", test.s, got, indexes, test.values, test.indexes)
+		}
+		if got := tokens.Reconstruct(); got != test.s {
+			t.Errorf("Split(%q).Reconstruct() -> %q", test.s, got)
+		}
+	}
+
+	if tokens, _ := SplitCursor("\uFEFFgit st", 7); tokens.CurrentToken().Value != "st" {
+		t.Errorf("SplitCursor(%q, 7).CurrentToken() -> %#v", "\uFEFFgit st", tokens.CurrentToken())
+	}
+}
+
+func TestSplitAssign(t *testing.T) {
+	tests := []struct {
+		s     string
+		key   string
+		value string
+		index int
+		ok    bool
+	}{
+		{"cmd --output=json", "--output", "json", 13, true},
+		{"cmd FOO=bar", "FOO", "bar", 8, true},
+		{"cmd key='long value'", "key", "long value", 8, true},
+		{"cmd a=b=c", "a", "b=c", 6, true},
+		{"cmd a==b", "a", "=b", 6, true},
+		{"cmd =foo", "", "foo", 5, true},
+		{"cmd a=", "a", "", 6, true},
+		{"cmd 'a=b'", "", "", 0, false},
+		{`cmd a\=b=c`, "a=b", "c", 9, true},
+		{`cmd "a"=b`, "a", "b", 8, true},
+		{`cmd "ä=ö"=ü`, "ä=ö", "ü", 10, true},
+		{"cmd plain", "", "", 0, false},
+	}
+	for _, test := range tests {
+		tokens, err := Split(test.s)
+		if err != nil {
+			t.Error(err)
+		}
+		word := tokens.Words()[1]
+		key, value, index, ok := word.SplitAssign()
+		if key != test.key || value != test.value || index != test.index || ok != test.ok {
+			t.Errorf("Split(%q).Words()[1].SplitAssign() -> %q, %q, %v, %v. Want: %q, %q, %v, %v", test.s, key, value, index, ok, test.key, test.value, test.index, test.ok)
+		}
+		if ok && string([]rune(test.s)[index:]) != string([]rune(word.RawValue)[index-word.Index:]) {
+			t.Errorf("Split(%q) value index %v does not match the input", test.s, index)
+		}
+	}
+}
+
+func TestRawOffsets(t *testing.T) {
+	tests := []struct {
+		s       string
+		offsets []int
+	}{
+		{`abc`, []int{0, 1, 2}},
+		{`a"b c"d`, []int{0, 2, 3, 4, 6}},
+		{`a\ b`, []int{0, 1, 3}},
+		{`'x'"\"y"`, []int{1, 4, 6}},
+		{`$'a\tb'`, []int{2, 3, 5}},
+		{`ä"ö"`, []int{0, 2}},
+	}
+	for _, test := range tests {
+		tokens, err := Split(test.s)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		words := tokens.Words()
+		if len(words) != 1 {
+			t.Errorf("Split(%#v).Words() -> %v words", test.s, len(words))
+			continue
+		}
+		if !reflect.DeepEqual(words[0].RawOffsets, test.offsets) {
+			t.Errorf("Split(%#v) -> %v. Want: %v", test.s, words[0].RawOffsets, test.offsets)
+		}
+	}
+
+	tokens, _ := Split(`a"b c"d`)
+	token := tokens[0]
+	if offset := token.RawOffset(2); offset != 3 {
+		t.Errorf("RawOffset(2) -> %v", offset)
+	}
+	if offset := token.RawOffset(5); offset != 7 {
+		t.Errorf("RawOffset(5) -> %v", offset)
+	}
+	if offset := token.ValueOffset(1); offset != 1 {
+		t.Errorf("ValueOffset(1) -> %v", offset)
+	}
+	if offset := token.ValueOffset(5); offset != 4 {
+		t.Errorf("ValueOffset(5) -> %v", offset)
+	}
+	if offset := token.ValueOffset(7); offset != 5 {
+		t.Errorf("ValueOffset(7) -> %v", offset)
+	}
+}
+
+func TestQuoteFunc(t *testing.T) {
+	tests := map[string]string{
+		"":              "''",
+		"abc":           "abc",
+		"a/b.c-d_e+f,g": "a/b.c-d_e+f,g",
+		"a b":           "'a b'",
+		"it's":          `'it'\''s'`,
+		`say "hi"`:      `'say "hi"'`,
+		`C:\dir`:        `'C:\dir'`,
+		"a\nb":          "'a\nb'",
+		"#tag":          "'#tag'",
+		"a|b&c;d":       "'a|b&c;d'",
+		"$HOME":         "'$HOME'",
+		"--flag=value":  "'--flag=value'",
+	}
+	for s, want := range tests {
+		if got := Quote(s); got != want {
+			t.Errorf("Quote(%q) -> %v. Want: %v", s, got, want)
+		}
+	}
+
+	roundtrip := func(s string) bool {
+		if strings.ContainsRune(s, 0) {
+			return true // NUL can't be passed as argument
+		}
+		tokens, err := Split(Quote(s))
+		return err == nil && len(tokens) == 1 && tokens[0].Value == s
+	}
+	if err := quick.Check(roundtrip, nil); err != nil {
+		t.Error(err)
+	}
+
+	alphabet := []rune(" \t\r\n\\'\"`$|&;<>()*?[]{}#~=:%!-aé")
+	shellish := func(indexes []uint8) bool {
+		runes := make([]rune, 0, len(indexes))
+		for _, index := range indexes {
+			runes = append(runes, alphabet[int(index)%len(alphabet)])
+		}
+		return roundtrip(string(runes))
+	}
+	if err := quick.Check(shellish, &quick.Config{MaxCount: 10000}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestJoinWith(t *testing.T) {
+	words := []string{"", " ", "\t\n", "plain", "a b", `it's "quoted"`, `C:\dir`, "$HOME", "a|b;c", "#tag", "--flag=value", "a:b", "~", "line\nbreak"}
+	tests := map[JoinStyle]string{
+		DEFAULT_JOIN_STYLE:      `"" " " "` + "\t\n" + `" plain "a b" "it's \"quoted\"" "C:\\dir" "\$HOME" "a|b;c" "#tag" --flag=value a:b ~ "line` + "\n" + `break"`,
+		SINGLE_QUOTE_JOIN_STYLE: `'' ' ' '` + "\t\n" + `' plain 'a b' 'it'\''s "quoted"' 'C:\dir' '$HOME' 'a|b;c' '#tag' --flag=value a:b ~ 'line` + "\n" + `break'`,
+		BACKSLASH_JOIN_STYLE:    `'' \  \` + "\t'\n'" + ` plain a\ b it\'s\ \"quoted\" C\:\\dir \$HOME a\|b\;c \#tag --flag=value a:b ~ line'` + "\n" + `'break`,
+		MINIMAL_JOIN_STYLE:      `'' \  '` + "\t\n" + `' plain a\ b it\'s\ \"quoted\" 'C:\dir' \$HOME 'a|b;c' \#tag --flag=value a:b ~ 'line` + "\n" + `break'`,
+	}
+	for style, want := range tests {
+		joined := JoinWith(words, style)
+		if joined != want {
+			t.Errorf("JoinWith(%v) -> %v. Want: %v", style, joined, want)
+		}
+		tokens, err := Split(joined)
+		if err != nil {
+			t.Error(err)
+		}
+		if got := tokens.Words().Strings(); !reflect.DeepEqual(got, words) {
+			t.Errorf("Split(JoinWith(%v)) -> %#v. Want: %#v", style, got, words)
+		}
+	}
+
+	if joined := Join(words); joined != tests[DEFAULT_JOIN_STYLE] {
+		t.Errorf("Join() -> %v. Want: %v", joined, tests[DEFAULT_JOIN_STYLE])
+	}
+}
+
+func TestUnquote(t *testing.T) {
+	tests := map[string]string{
+		``:                   "",
+		`abc`:                "abc",
+		` 'a b' `:            "a b",
+		`"it's \"x\""`:       `it's "x"`,
+		`a\ b`:               "a b",
+		`'it'\''s'`:          "it's",
+		`$'a\tb'`:            "a\tb",
+		`--flag="a b"`:       "--flag=a b",
+		`"$HOME"/x`:          "$HOME/x",
+		`a # comment`:        "a",
+		"'line\nbreak'":      "line\nbreak",
+		Quote("a'b \"c\" #"): "a'b \"c\" #",
+	}
+	for s, want := range tests {
+		if got, err := Unquote(s); err != nil || got != want {
+			t.Errorf("Unquote(%q) -> %q, %v. Want: %q", s, got, err, want)
+		}
+	}
+
+	var unclosedQuoteError *UnclosedQuoteError
+	if _, err := Unquote(`'a b`); !errors.As(err, &unclosedQuoteError) {
+		t.Errorf("Unquote() -> %#v. Want: UnclosedQuoteError", err)
+	}
+	var trailingEscapeError *TrailingEscapeError
+	if _, err := Unquote(`a\`); !errors.As(err, &trailingEscapeError) {
+		t.Errorf("Unquote() -> %#v. Want: TrailingEscapeError", err)
+	}
+	for s, index := range map[string]int{`a b`: 2, `'a' "b"`: 4, `a|b`: 1, `a; `: 1, `a>b`: 1, `(a)`: 0} {
+		var multipleWordsError *MultipleWordsError
+		if _, err := Unquote(s); !errors.As(err, &multipleWordsError) || multipleWordsError.Index != index {
+			t.Errorf("Unquote(%q) -> %#v. Want: MultipleWordsError at %v", s, err, index)
+		}
+	}
+}
+
+func TestEscapeFor(t *testing.T) {
+	s := "a b'c\"d$e\\f`g|h#i\nj~"
+	tests := []struct {
+		state  LexerState
+		prefix string // line up to the cursor
+		value  string // value of the current word up to the cursor
+		opts   []Option
+	}{
+		{START_STATE, "echo ", "", nil},
+		{IN_WORD_STATE, "echo pre", "pre", nil},
+		{ESCAPING_STATE, `echo pre\`, "pre", nil},
+		{QUOTING_ESCAPING_STATE, `echo "pre`, "pre", nil},
+		{ESCAPING_QUOTED_STATE, `echo "pre\`, "pre", nil},
+		{QUOTING_STATE, `echo 'pre`, "pre", nil},
+		{ANSI_C_QUOTING_STATE, `echo $'pre`, "pre", nil},
+		{ESCAPING_ANSI_C_STATE, `echo $'pre\`, "pre", nil},
+		{WORDBREAK_STATE, `echo --flag=`, "--flag=", nil},
+		{GROUP_STATE, `(`, "(", nil},
+		{SPACE_STATE, `echo `, "", []Option{WithSpaceTokens(true)}},
+		{COMMENT_STATE, `echo #pre`, "pre", nil},
+		{HEREDOC_STATE, "cat <<EOF\npre", "pre", nil},
+		{SUBSTITUTION_STATE, "echo $(pre ", "", nil},
+		{ARITHMETIC_STATE, "echo $((pre ", "", nil},
+		{BACKQUOTING_STATE, "echo `pre ", "", nil},
+		{ESCAPING_BACKQUOTED_STATE, "echo `pre \\", "", nil},
+	}
+	for _, test := range tests {
+		tokenizer := NewTokenizer(strings.NewReader(test.prefix), test.opts...)
+		var last *Token
+		for {
+			token, err := tokenizer.Next()
+			if err != nil {
+				break
+			}
+			last = token
+		}
+		switch test.state {
+		case WORDBREAK_STATE, GROUP_STATE, SPACE_STATE:
+			// followed by a new token in START_STATE
+		default:
+			if last == nil || last.State != test.state {
+				t.Errorf("Tokenizer(%q) -> %#v. Want: %v", test.prefix, last, test.state)
+				continue
+			}
+		}
+
+		s := s
+		if test.state == COMMENT_STATE {
+			s = strings.Replace(s, "\n", " ", -1) // newline ends the comment
+		}
+		line := test.prefix + EscapeFor(test.state, s)
+		var got string
+		switch test.state {
+		case SUBSTITUTION_STATE, ARITHMETIC_STATE, BACKQUOTING_STATE, ESCAPING_BACKQUOTED_STATE:
+			// content is kept as is and split again on execution
+			content := line[strings.IndexAny(line, "(`")+1:]
+			content = strings.TrimLeft(content, "(")
+			tokens, err := Split(content)
+			if err != nil {
+				t.Error(err)
+			}
+			got = tokens.Words()[len(tokens.Words())-1].Value
+		case COMMENT_STATE, HEREDOC_STATE:
+			tokens, err := Tokenize(line)
+			if err != nil {
+				t.Error(err)
+			}
+			got = tokens[len(tokens)-1].Value
+		default:
+			tokens, err := Split(line, test.opts...)
+			if err != nil {
+				t.Error(err)
+			}
+			got = tokens.Words()[len(tokens.Words())-1].Value
+		}
+		if got != test.value+s {
+			t.Errorf("EscapeFor(%v) -> %q -> %q. Want: %q", test.state, line, got, test.value+s)
+		}
+	}
+
+	if escaped := EscapeFor(IN_WORD_STATE, ""); escaped != "" {
+		t.Errorf("EscapeFor() -> %q. Want: %q", escaped, "")
+	}
+}
+
+func TestJoinDialect(t *testing.T) {
+	words := []string{"", "plain", "a b", `it's`, `say "hi"`, `C:\Program Files\x`, "$HOME", "a|b&c", "^caret", "(x)", "#tag"}
+	tests := map[Dialect]string{
+		BASH_DIALECT:       `'' plain 'a b' 'it'\''s' 'say "hi"' 'C:\Program Files\x' '$HOME' 'a|b&c' ^caret '(x)' '#tag'`,
+		ZSH_DIALECT:        `'' plain 'a b' 'it'\''s' 'say "hi"' 'C:\Program Files\x' '$HOME' 'a|b&c' ^caret '(x)' '#tag'`,
+		FISH_DIALECT:       `'' plain 'a b' 'it\'s' 'say "hi"' 'C:\\Program Files\\x' '$HOME' 'a|b&c' ^caret '(x)' '#tag'`,
+		POWERSHELL_DIALECT: `'' plain 'a b' 'it''s' 'say "hi"' 'C:\Program Files\x' '$HOME' 'a|b&c' ^caret '(x)' '#tag'`,
+		CMD_DIALECT:        `"" plain "a b" it's "say "^""hi"^""" "C:\Program Files\x" $HOME "a|b&c" "^caret" "(x)" #tag`,
+	}
+	for d, want := range tests {
+		joined := JoinDialect(words, d)
+		if joined != want {
+			t.Errorf("JoinDialect(%v) -> %v. Want: %v", d, joined, want)
+		}
+		tokens, err := Split(joined, WithDialect(d))
+		if err != nil {
+			t.Error(err)
+		}
+		if got := tokens.Words().Strings(); !reflect.DeepEqual(got, words) {
+			t.Errorf("Split(JoinDialect(%v)) -> %#v. Want: %#v", d, got, words)
+		}
+		if quoted := QuoteDialect("a b", d); !strings.HasPrefix(want, `"" plain `+quoted) && !strings.HasPrefix(want, `'' plain `+quoted) {
+			t.Errorf("QuoteDialect(%v) -> %v", d, quoted)
+		}
+	}
+}
+
+func TestSplitN(t *testing.T) {
+	tests := []struct {
+		s         string
+		n         int
+		words     []string
+		remainder string
+	}{
+		{`watch -n 1 "ls -la" | wc`, 1, []string{"watch"}, `-n 1 "ls -la" | wc`},
+		{`watch -n 1 "ls -la" | wc`, 3, []string{"watch", "-n", "1"}, `"ls -la" | wc`},
+		{`watch   --interval=1 	 ls`, 2, []string{"watch", "--interval=1"}, "ls"},
+		{`a | b`, 1, []string{"a"}, "| b"},
+		{`a | b`, 2, []string{"a", "|"}, "b"},
+		{"a # comment\n b", 1, []string{"a"}, "# comment\n b"},
+		{`ä "ö ü" 'rest`, 2, []string{"ä", "ö ü"}, "'rest"},
+		{`a b`, 2, []string{"a", "b"}, ""},
+		{`a b `, 2, []string{"a", "b"}, ""},
+		{`a b `, 3, []string{"a", "b", ""}, ""},
+		{` a b`, 0, []string{}, " a b"},
+		{` a b`, -1, []string{}, " a b"},
+	}
+	for _, test := range tests {
+		tokens, remainder, err := SplitN(test.s, test.n)
+		if err != nil {
+			t.Error(err)
+		}
+		if got := tokens.Words().Strings(); !reflect.DeepEqual(got, test.words) || remainder != test.remainder {
+			t.Errorf("SplitN(%q, %v) -> %#v, %q. Want: %#v, %q", test.s, test.n, got, remainder, test.words, test.remainder)
+		}
+	}
+
+	if _, _, err := SplitN(`a 'b`, 5, WithStrict(true)); err == nil {
+		t.Error("SplitN() should fail in strict mode")
+	}
+}
+
+func TestSplitLines(t *testing.T) {
+	s := "git status\n\n# comment\necho 'a\nb' c\\\nd\r\ncat <<EOF | wc\nx\nEOF\nls # end\n"
+	lines, err := SplitLines(strings.NewReader(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make([][]string, 0)
+	for _, line := range lines {
+		got = append(got, line.Strings())
+	}
+	want := [][]string{{"git", "status"}, {"echo", "a\nb", "cd"}, {"cat", "<<", "EOF", "|", "wc", "x\n"}, {"ls"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("SplitLines(%q) -> %#v. Want: %#v", s, got, want)
+	}
+	if index := lines[1][0].Index; index != 22 {
+		t.Errorf("SplitLines(%q)[1][0].Index -> %v. Want: 22", s, index)
+	}
+
+	for s, want := range map[string][][]string{
+		"h \\\ni":           {{"h", "i"}},
+		"h \\\r\ni\nj":      {{"h", "i"}, {"j"}},
+		"h # c \\\ni":       {{"h"}, {"i"}},
+		"h \\\n # c\ni":     {{"h"}, {"i"}},
+		"h \\\n\\\n i \\\n": {{"h", "i"}},
+	} {
+		lines, err := SplitLines(strings.NewReader(s))
+		if err != nil {
+			t.Error(err)
+		}
+		got := make([][]string, 0)
+		for _, line := range lines {
+			got = append(got, line.Strings())
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("SplitLines(%q) -> %#v. Want: %#v", s, got, want)
+		}
+	}
+
+	if lines, err := SplitLines(strings.NewReader("")); err != nil || len(lines) != 0 {
+		t.Errorf("SplitLines(%q) -> %#v, %v", "", lines, err)
+	}
+
+	for s, line := range map[string]int{
+		"a\nb\n'c\nd":     3,
+		"a\nä\nö \"c":     3,
+		"a\n\n\nb \\":     4,
+		"echo 'x\ny' \"z": 2,
+	} {
+		_, err := SplitLines(strings.NewReader(s), WithStrict(true))
+		var lineError *LineError
+		if !errors.As(err, &lineError) || lineError.Line != line || !errors.As(err, new(*LexError)) {
+			t.Errorf("SplitLines(%q) -> %#v. Want: error in line %v", s, err, line)
+		}
+	}
+}
+
+func TestSplitCursor(t *testing.T) {
+	tests := []struct {
+		s      string
+		cursor int
+		words  []string
+		state  LexerState
+	}{
+		{`git commit -m "message" --amend`, 4, []string{"git", ""}, START_STATE},
+		{`git commit -m "message" --amend`, 6, []string{"git", "co"}, IN_WORD_STATE},
+		{`git commit -m "message" --amend`, 17, []string{"git", "commit", "-m", "me"}, QUOTING_ESCAPING_STATE},
+		{`echo 'äöü' x`, 8, []string{"echo", "äö"}, QUOTING_STATE},
+		{`echo a\ b`, 7, []string{"echo", "a"}, ESCAPING_STATE},
+		{`echo a`, 99, []string{"echo", "a"}, IN_WORD_STATE},
+		{`echo a`, -1, []string{""}, START_STATE},
+	}
+	for _, test := range tests {
+		tokens, err := SplitCursor(test.s, test.cursor)
+		if err != nil {
+			t.Error(err)
+		}
+		current := tokens.CurrentToken()
+		if got := tokens.Words().Strings(); !reflect.DeepEqual(got, test.words) || current.State != test.state {
+			t.Errorf("SplitCursor(%q, %v) -> %#v, %v. Want: %#v, %v", test.s, test.cursor, got, current.State, test.words, test.state)
+		}
+	}
+
+	tokens, err := SplitCursor(`echo "ä b" 'c`, 13)
+	if err != nil {
+		t.Error(err)
+	}
+	if current := tokens.CurrentToken(); current.Index != 11 || current.EndIndex != 13 || !current.Unterminated || current.Value != "c" {
+		t.Errorf("SplitCursor() -> %#v", current)
+	}
+}
+
+func TestTokenState(t *testing.T) {
+	tests := map[string]LexerState{
+		`echo "a`:  QUOTING_ESCAPING_STATE,
+		`echo 'a`:  QUOTING_STATE,
+		`echo "a\`: ESCAPING_QUOTED_STATE,
+		`echo a\`:  ESCAPING_STATE,
+		`echo $'a`: ANSI_C_QUOTING_STATE,
+		`echo $(a`: SUBSTITUTION_STATE,
+		"echo `a":  BACKQUOTING_STATE,
+		`echo a`:   IN_WORD_STATE,
+		`echo `:    START_STATE,
+		`echo #a`:  COMMENT_STATE,
+	}
+	for s, want := range tests {
+		tokens, err := Tokenize(s)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if got := tokens[len(tokens)-1].State; got != want {
+			t.Errorf("Tokenize(%q) last state -> %v. Want: %v", s, got, want)
+		}
+	}
+}
+
+func TestByteIndex(t *testing.T) {
+	s := `résumé "füü bär" x|ö`
+	tokens, err := Split(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, token := range tokens {
+		if got := s[token.ByteIndex:token.ByteEndIndex]; got != token.RawValue {
+			t.Errorf("input at byte offsets of %#v is %q", token, got)
+		}
+		if got := string([]rune(s)[token.Index:token.EndIndex]); got != token.RawValue {
+			t.Errorf("input at rune indexes of %#v is %q", token, got)
+		}
+	}
+	if got := []int{tokens[1].Index, tokens[1].EndIndex, tokens[1].ByteIndex, tokens[1].ByteEndIndex}; !reflect.DeepEqual(got, []int{7, 16, 9, 21}) {
+		t.Errorf("indexes of %q: %v", tokens[1].RawValue, got)
+	}
+
+	if words := tokens.Words(); words[2].ByteIndex != 22 || words[2].ByteEndIndex != 26 {
+		t.Errorf("byte offsets of merged word %#v", words[2])
+	}
+
+	for cursor, want := range map[int][]string{
+		2:  {"r"},
+		4:  {"rés"},
+		15: {"résumé", "füü"},
+		14: {"résumé", "fü"},
+		99: {"résumé", "füü bär", "x", "|", "ö"},
+	} {
+		tokens, err := SplitByteCursor(s, cursor)
+		if err != nil {
+			t.Error(err)
+		}
+		if got := tokens.Strings(); !reflect.DeepEqual(got, want) {
+			t.Errorf("SplitByteCursor(%q, %v) -> %#v. Want: %#v", s, cursor, got, want)
+		}
+	}
+}
+
+func TestTokenize(t *testing.T) {
+	for _, s := range []string{
+		testString,
+		"  echo a # comment\n\tb 'c d' # end",
+		"cat <<EOF # c\nbody\nEOF\nls 2>&1 | wc -l &",
+		"echo 'unclosed",
+	} {
+		split, err := Split(s)
+		if err != nil {
+			t.Error(err)
+		}
+		for _, opts := range [][]Option{nil, {WithSpaceTokens(true)}} {
+			tokens, err := Tokenize(s, opts...)
+			if err != nil {
+				t.Error(err)
+			}
+			words := make([]string, 0)
+			for _, token := range tokens {
+				if token.Type != COMMENT_TOKEN && token.Type != SPACE_TOKEN {
+					words = append(words, token.RawValue)
+				}
+			}
+			want := make([]string, 0)
+			for _, token := range split {
+				want = append(want, token.RawValue)
+			}
+			if !reflect.DeepEqual(words, want) {
+				t.Errorf("Tokenize(%q) -> %#v. Want: %#v", s, words, want)
+			}
+		}
+	}
+}
+
+func TestSkipTokens(t *testing.T) {
+	source := []Token{
+		{Type: WORD_TOKEN, Value: "a", RawValue: "a"},
+		{Type: SPACE_TOKEN, Value: " ", RawValue: " "},
+		{Type: UNKNOWN_TOKEN, Value: "?", RawValue: "?"},
+		{Type: COMMENT_TOKEN, Value: "c", RawValue: "#c", Leading: " "},
+		{Type: TokenType(99), Value: "x", RawValue: "x", Leading: "\n"},
+	}
+	next := func(token *Token) error {
+		if len(source) == 0 {
+			return io.EOF
+		}
+		*token = source[0]
+		source = source[1:]
+		return nil
+	}
+
+	tokens := make(TokenSlice, 0)
+	for {
+		var token Token
+		if err := skipTokens(&token, next); err != nil {
+			if err != io.EOF {
+				t.Error(err)
+			}
+			break
+		}
+		tokens = append(tokens, token)
+	}
+	want := TokenSlice{
+		{Type: WORD_TOKEN, Value: "a", RawValue: "a"},
+		{Type: UNKNOWN_TOKEN, Value: "?", RawValue: "?", Leading: " "},
+		{Type: TokenType(99), Value: "x", RawValue: "x", Leading: " #c\n"},
+	}
+	if !tokens.Equal(want) {
+		t.Errorf("skipTokens() \nGot : %#v\nWant: %#v", tokens, want)
+	}
+}
+
+func TestReset(t *testing.T) {
+	l := NewLexer(strings.NewReader("cat <<EOF\nbody"), WithCommentRunes(""))
+	for {
+		if _, err := l.Next(); err != nil {
+			break
+		}
+	}
+
+	s := "echo a #b | (c"
+	l.Reset(strings.NewReader(s))
+	tokens := make(TokenSlice, 0)
+	for {
+		token, err := l.Next()
+		if err != nil {
+			break
+		}
+		tokens = append(tokens, *token)
+	}
+	want, err := Split(s, WithCommentRunes(""))
+	if err != nil {
+		t.Error(err)
+	}
+	if !tokens.Equal(want) {
+		t.Errorf("Lexer.Reset() \nGot : %#v\nWant: %#v", tokens, want)
+	}
+
+	l = NewLexerString("x y", WithCommentRunes(""))
+	l.Next()
+	l.Reset(strings.NewReader(s))
+	tokens = make(TokenSlice, 0)
+	for {
+		token, err := l.Next()
+		if err != nil {
+			break
+		}
+		tokens = append(tokens, *token)
+	}
+	if !tokens.Equal(want) {
+		t.Errorf("NewLexerString().Reset() \nGot : %#v\nWant: %#v", tokens, want)
+	}
+}
+
+// tokenizers are the constructors of tokenizers reading a string, which must behave identically.
+var tokenizers = []struct {
+	name string
+	new  func(s string, opts ...Option) *Tokenizer
+}{
+	{"NewTokenizer", func(s string, opts ...Option) *Tokenizer { return NewTokenizer(strings.NewReader(s), opts...) }},
+	{"NewTokenizerString", NewTokenizerString},
+}
+
+// corpus contains inputs covering the features of the tokenizer.
+var corpus = []string{
+	testString,
+	"",
+	" ",
+	"\ufeffecho a",
+	"echo \"a b\" 'c d' e\\ f \"g\\\"h\" $'i\\tj' $\"k\"",
+	"echo 'unclosed",
+	"echo \"unclosed \\",
+	"echo a\\",
+	"echo a\\\nb \\\r\nc",
+	"echo $HOME ${PATH}x $1$$ ~/a* ~user !! !$",
+	"echo $(ls 'a)' \"$(id)\") `date` $((1 + (2 * 3))) <(sort a) >(wc)",
+	"echo \"$(echo 'a",
+	"echo $((1 +",
+	"a | b |& c && d || e; f & g ;; h ;& i",
+	"cmd 2>err >>out &>all <in 3<&0 >|clobber 2>&1",
+	"(cd /tmp && ls) | { wc -l; }",
+	"{ echo a # c\n}",
+	"echo a # comment\r\nls # end",
+	"echo # c\n",
+	"cat <<EOF # c\r\nbody\nEOF\n$(a \"b",
+	"cat <<-'EOF' <<X\n\ta $b\n\tEOF\nx\nX\nls",
+	"cat <<EOF",
+	"case $x in a|b) echo a ;; *) echo b ;; esac",
+	"a=1 b=\"2 3\" cmd --flag=value a:b",
+	"echo\u00a0a\u2003b",
+	"a\xc0\\\r\n'b",
+	"echo a\xc0\xafb \"\xe2\x82\" \x80 ok",
+}
+
+func TestTokenizers(t *testing.T) {
+	tokenize := func(next func() (*Token, error)) (TokenSlice, error) {
+		tokens := make(TokenSlice, 0)
+		for {
+			token, err := next()
+			if err == io.EOF {
+				return tokens, nil
+			}
+			if err != nil {
+				return tokens, err
+			}
+			tokens = append(tokens, *token)
+		}
+	}
+
+	options := [][]Option{
+		nil,
+		{WithSpaceTokens(true)},
+		{WithStrict(true)},
+		{WithUnicodeSpaces(true)},
+		{WithInvalidUTF8(PASS_THROUGH_INVALID_UTF8)},
+		{WithInvalidUTF8(ERROR_ON_INVALID_UTF8)},
+	}
+	for _, s := range corpus {
+		for _, opts := range options {
+			var want, wantLexed TokenSlice
+			var wantErr, wantLexedErr error
+			for index, tokenizer := range tokenizers {
+				tokens, err := tokenize(tokenizer.new(s, opts...).Next)
+				lexed, lexedErr := tokenize((*Lexer)(tokenizer.new(s, opts...)).Next)
+				if index == 0 {
+					want, wantErr, wantLexed, wantLexedErr = tokens, err, lexed, lexedErr
+					continue
+				}
+				if !tokens.Equal(want) || !reflect.DeepEqual(err, wantErr) {
+					t.Errorf("%v(%q) \nGot : %#v, %v\nWant: %#v, %v", tokenizer.name, s, tokens, err, want, wantErr)
+				}
+				if !lexed.Equal(wantLexed) || !reflect.DeepEqual(lexedErr, wantLexedErr) {
+					t.Errorf("%v(%q) as Lexer \nGot : %#v, %v\nWant: %#v, %v", tokenizer.name, s, lexed, lexedErr, wantLexed, wantLexedErr)
+				}
+			}
+		}
+	}
+}
+
+func TestPeek(t *testing.T) {
+	s := "a | b && c"
+	want := make([]*Token, 0)
+	tokenizer := NewTokenizer(strings.NewReader(s))
+	for {
+		token, err := tokenizer.Next()
+		if err != nil {
+			break
+		}
+		want = append(want, token)
+	}
+
+	tokenizer = NewTokenizer(strings.NewReader(s))
+	got := make([]*Token, 0)
+	for i := 0; ; i++ {
+		peeked, err := tokenizer.Peek()
+		if i%2 == 0 { // peek twice every other token
+			if again, _ := tokenizer.Peek(); again != peeked {
+				t.Errorf("Peek() returned a different token: %#v", again)
+			}
+		}
+		token, nextErr := tokenizer.Next()
+		if err != nextErr {
+			t.Fatalf("Peek() error %v differs from Next() error %v", err, nextErr)
+		}
+		if err != nil {
+			break
+		}
+		if token != peeked {
+			t.Errorf("Next() \nGot : %#v\nWant: %#v", token, peeked)
+		}
+		got = append(got, token)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Peek() \nGot : %#v\nWant: %#v", got, want)
+	}
+
+	tokenizer = NewTokenizer(strings.NewReader(s))
+	first, _ := tokenizer.Next()
+	second, _ := tokenizer.Next()
+	tokenizer.PushBack(second)
+	tokenizer.PushBack(first)
+	for _, expected := range []*Token{first, second, want[2]} {
+		if token, _ := tokenizer.Next(); !token.Equal(expected) {
+			t.Errorf("PushBack() \nGot : %#v\nWant: %#v", token, expected)
+		}
+	}
+}
+
+func TestScanTokens(t *testing.T) {
+	for _, s := range []string{
+		testString,
+		"",
+		"echo 'a b' \"c d\" # comment\nls|wc -l&&x",
+		"cat <<EOF\nbody\nEOF\necho $(a 'b c') `d`",
+		"a ",
+	} {
+		split, err := Split(s)
+		if err != nil {
+			t.Error(err)
+		}
+		want := make([]string, 0)
+		for _, token := range split {
+			if token.RawValue != "" {
+				want = append(want, token.RawValue)
+			}
+		}
+
+		scanner := bufio.NewScanner(iotest.OneByteReader(strings.NewReader(s)))
+		scanner.Split(ScanTokens())
+		got := make([]string, 0)
+		for scanner.Scan() {
+			got = append(got, scanner.Text())
+		}
+		if err := scanner.Err(); err != nil {
+			t.Error(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("ScanTokens(%q) -> %#v. Want: %#v", s, got, want)
+		}
+	}
+
+	s := "echo '" + strings.Repeat("a", 64) + "' b"
+	scanner := bufio.NewScanner(strings.NewReader(s))
+	scanner.Buffer(make([]byte, 16), 32)
+	scanner.Split(ScanTokens())
+	for scanner.Scan() {
+	}
+	if err := scanner.Err(); err != bufio.ErrTooLong {
+		t.Errorf("ScanTokens(%q) with small buffer -> %v. Want: %v", s, err, bufio.ErrTooLong)
+	}
+
+	scanner = bufio.NewScanner(strings.NewReader(s))
+	scanner.Buffer(make([]byte, 16), 128)
+	scanner.Split(ScanTokens())
+	got := make([]string, 0)
+	for scanner.Scan() {
+		got = append(got, scanner.Text())
+	}
+	if want := []string{"echo", "'" + strings.Repeat("a", 64) + "'", "b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ScanTokens(%q) with grown buffer -> %#v. Want: %#v", s, got, want)
+	}
+
+	scanner = bufio.NewScanner(strings.NewReader("echo 'unclosed"))
+	scanner.Split(ScanTokens(WithStrict(true)))
+	for scanner.Scan() {
+	}
+	if err := scanner.Err(); !errors.As(err, new(*UnclosedQuoteError)) {
+		t.Errorf("ScanTokens() in strict mode -> %v. Want: UnclosedQuoteError", err)
+	}
+}
+
+func TestNextContext(t *testing.T) {
+	reader, writer := io.Pipe()
+	tokenizer := NewTokenizer(reader)
+	go writer.Write([]byte("echo a"))
+
+	if token, err := tokenizer.NextContext(context.Background()); err != nil || token.Value != "echo" {
+		t.Errorf("Tokenizer.NextContext() -> %#v, %v. Want: echo", token, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if token, err := tokenizer.NextContext(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Tokenizer.NextContext() -> %#v, %v. Want: %v", token, err, context.DeadlineExceeded)
+	}
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := tokenizer.NextContext(cancelled); err != context.Canceled {
+		t.Errorf("Tokenizer.NextContext() -> %v. Want: %v", err, context.Canceled)
+	}
+
+	go func() {
+		writer.Write([]byte(" b"))
+		writer.Close()
+	}()
+	values := make([]string, 0)
+	for {
+		token, err := tokenizer.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		values = append(values, token.Value)
+	}
+	if want := []string{"a", "b"}; !reflect.DeepEqual(values, want) {
+		t.Errorf("Tokenizer.Next() after cancellation -> %#v. Want: %#v", values, want)
+	}
+}
+
+func TestLexerTokenizerConversion(t *testing.T) {
+	s := `a "b c" | d 'e' && f g; h`
+	lexer := NewLexer(strings.NewReader(s))
+	got := make([]string, 0)
+	for i := 0; ; i++ {
+		var token *Token
+		var err error
+		if i%2 == 0 {
+			token, err = lexer.Next()
+		} else {
+			token, err = (*Tokenizer)(lexer).Next()
+		}
+		if err != nil {
+			break
+		}
+		got = append(got, token.RawValue)
+	}
+
+	want, err := Split(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantRaw := make([]string, 0)
+	for _, token := range want {
+		wantRaw = append(wantRaw, token.RawValue)
+	}
+	if !reflect.DeepEqual(got, wantRaw) {
+		t.Errorf("conversion \nGot : %#v\nWant: %#v", got, wantRaw)
+	}
+}
+
+func TestSplitInto(t *testing.T) {
+	s := `git commit -m "message" --amend | tee log # comment`
+	want, err := Split(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prefix := TokenSlice{{Type: WORD_TOKEN, Value: "x", RawValue: "x"}}
+	tokens, err := SplitInto(s, prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tokens[:1].Equal(prefix) || !tokens[1:].Equal(want) {
+		t.Errorf("SplitInto() \nGot : %#v\nWant: %#v", tokens, append(prefix, want...))
+	}
+
+	tokens, err = SplitInto(`a "b`, tokens[:0], WithStrict(true))
+	if err == nil || len(tokens) != 0 {
+		t.Errorf("SplitInto() should return an error and dst unchanged: %#v", tokens)
+	}
+
+	tokens, _ = SplitInto("echo a $B", tokens[:0])
+	if got := tokens.Strings(); !reflect.DeepEqual(got, []string{"echo", "a", "$B"}) {
+		t.Errorf("SplitInto() with reused tokens \nGot : %#v", got)
+	}
+
+	s = `git commit -m "message" --amend | tee 'log'`
+	if want, err = Split(s); err != nil {
+		t.Fatal(err)
+	}
+	tokens, _ = SplitInto(s, tokens[:0])
+	if allocs := testing.AllocsPerRun(100, func() {
+		tokens, err = SplitInto(s, tokens[:0])
+	}); allocs != 0 && !raceEnabled {
+		t.Errorf("SplitInto() allocates %v times per run", allocs)
+	}
+	if err != nil || !tokens.Equal(want) {
+		t.Errorf("SplitInto() \nGot : %#v\nWant: %#v", tokens, want)
+	}
+}
+
+func TestSharedClassifier(t *testing.T) {
+	Split("a b", WithCommentRunes("%"))
+	Split("a b", WithDialect(CMD_DIALECT))
+	if got, _ := Split("a %b ^c #d"); !reflect.DeepEqual(got.Strings(), []string{"a", "%b", "^c"}) {
+		t.Errorf("shared default classifier was modified: %#v", got.Strings())
+	}
+}
+
+func BenchmarkSplit(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		Split(`git commit -m "message" --amend | tee log`)
+	}
+}
+
+func BenchmarkSplitLongWord(b *testing.B) {
+	s := `echo "` + strings.Repeat("a b ", 1<<18) + `"`
+	b.ReportAllocs()
+	b.SetBytes(int64(len(s)))
+	for i := 0; i < b.N; i++ {
+		Split(s)
+	}
+}
+
+func BenchmarkSplitLongLine(b *testing.B) {
+	s := strings.Repeat(`git commit -m "message" --amend | tee log; `, 100)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(s)))
+	for i := 0; i < b.N; i++ {
+		Split(s)
+	}
+}
+
+func BenchmarkSplitInto(b *testing.B) {
+	b.ReportAllocs()
+	tokens := make(TokenSlice, 0)
+	for i := 0; i < b.N; i++ {
+		tokens, _ = SplitInto(`git commit -m "message" --amend | tee log`, tokens[:0])
+	}
+}
+
+func BenchmarkLexerReset(b *testing.B) {
+	b.ReportAllocs()
+	r := strings.NewReader("")
+	l := NewLexer(r)
+	for i := 0; i < b.N; i++ {
+		r.Reset(`git commit -m "message" --amend | tee log`)
+		l.Reset(r)
+		for {
+			if _, err := l.Next(); err != nil {
+				break
+			}
+		}
+	}
+}
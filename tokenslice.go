@@ -1,11 +1,46 @@
 package shlex
 
 import (
+	"fmt"
+	"io"
 	"strconv"
+	"strings"
+	"unicode/utf8"
 )
 
 type TokenSlice []Token
 
+// Clone returns a deep copy of the tokens.
+func (t TokenSlice) Clone() TokenSlice {
+	if t == nil {
+		return nil
+	}
+	clone := make(TokenSlice, len(t))
+	for index, token := range t {
+		if token.Expansions != nil {
+			token.Expansions = append([]Expansion{}, token.Expansions...)
+		}
+		if token.RawOffsets != nil {
+			token.RawOffsets = append([]int{}, token.RawOffsets...)
+		}
+		clone[index] = token
+	}
+	return clone
+}
+
+// Equal reports whether both contain equal tokens (see Token.Equal).
+func (t TokenSlice) Equal(other TokenSlice) bool {
+	if len(t) != len(other) {
+		return false
+	}
+	for index := range t {
+		if !t[index].Equal(&other[index]) {
+			return false
+		}
+	}
+	return true
+}
+
 func (t TokenSlice) Strings() []string {
 	s := make([]string, 0, len(t))
 	for _, token := range t {
@@ -14,13 +49,42 @@ func (t TokenSlice) Strings() []string {
 	return s
 }
 
+// WriteValues writes the values separated by sep to w and returns the number of bytes written.
+func (t TokenSlice) WriteValues(w io.Writer, sep string) (int64, error) {
+	var written int64
+	for index, token := range t {
+		if index > 0 {
+			n, err := io.WriteString(w, sep)
+			written += int64(n)
+			if err != nil {
+				return written, err
+			}
+		}
+		n, err := io.WriteString(w, token.Value)
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// WriteValuesNul writes the values separated by NUL to w (as expected by `xargs -0`).
+func (t TokenSlice) WriteValuesNul(w io.Writer) (int64, error) {
+	return t.WriteValues(w, "\x00")
+}
+
+// Pipelines splits the tokens at pipeline delimiters and groups (excluding these).
+// Consecutive delimiters (`a; ;b`) result in empty pipelines so every delimiter separates two of them.
+// Multi-rune operators like `|&` and the case terminators `;;`, `;&` and `;;&` are a single delimiter.
 func (t TokenSlice) Pipelines() []TokenSlice {
 	pipelines := make([]TokenSlice, 0)
 
 	pipeline := make(TokenSlice, 0)
 	for _, token := range t {
 		switch {
-		case token.Type == WORDBREAK_TOKEN && wordbreakType(token).IsPipelineDelimiter():
+		case token.Type == WORDBREAK_TOKEN && token.WordbreakType.IsPipelineDelimiter(),
+			token.Type == GROUP_TOKEN:
 			pipelines = append(pipelines, pipeline)
 			pipeline = make(TokenSlice, 0)
 		default:
@@ -30,20 +94,58 @@ func (t TokenSlice) Pipelines() []TokenSlice {
 	return append(pipelines, pipeline)
 }
 
+// Statements splits the tokens at command terminators (`;`, `&`, `&&`, `||` and newlines) and groups (excluding these).
+// In contrast to Pipelines the commands of a pipeline (`a | b`) stay within the same statement.
+func (t TokenSlice) Statements() []TokenSlice {
+	statements := make([]TokenSlice, 0)
+
+	statement := make(TokenSlice, 0)
+	for _, token := range t {
+		if token.Type != HEREDOC_TOKEN && unescapedNewline(token.Leading) && len(statement) > 0 {
+			statements = append(statements, statement)
+			statement = make(TokenSlice, 0)
+		}
+
+		switch {
+		case token.Type == WORDBREAK_TOKEN && token.WordbreakType.IsPipelineDelimiter() && !token.WordbreakType.IsPipe(),
+			token.Type == GROUP_TOKEN:
+			statements = append(statements, statement)
+			statement = make(TokenSlice, 0)
+		default:
+			statement = append(statement, token)
+		}
+	}
+	return append(statements, statement)
+}
+
+// CurrentPipeline returns the last pipeline (see Pipelines).
+// After a trailing pipeline delimiter (`a |`, `a && `) it only contains the empty word
+// at the end of the input, so completion starts with a new command.
 func (t TokenSlice) CurrentPipeline() TokenSlice {
 	pipelines := t.Pipelines()
 	return pipelines[len(pipelines)-1]
 }
 
+// Words merges adjoining tokens (`--opt=a"b"`) into words, which span from the position of their
+// first token to the end of their last one. Use Strings for just the values.
 func (t TokenSlice) Words() TokenSlice {
 	words := make(TokenSlice, 0)
 	for index, token := range t {
 		switch {
 		case index == 0:
 			words = append(words, token)
-		case t[index-1].adjoins(token):
+		case t[index-1].Adjoins(&token):
+			offset := utf8.RuneCountInString(words[len(words)-1].RawValue)
+			rawOffsets := words[len(words)-1].RawOffsets
+			rawOffsets = rawOffsets[:len(rawOffsets):len(rawOffsets)] // don't append to the backing array of the original token
+			for _, rawOffset := range token.RawOffsets {
+				rawOffsets = append(rawOffsets, offset+rawOffset)
+			}
+			words[len(words)-1].RawOffsets = rawOffsets
 			words[len(words)-1].Value += token.Value
 			words[len(words)-1].RawValue += token.RawValue
+			words[len(words)-1].EndIndex = token.EndIndex
+			words[len(words)-1].ByteEndIndex = token.ByteEndIndex
 			words[len(words)-1].State = token.State
 		default:
 			words = append(words, token)
@@ -52,37 +154,105 @@ func (t TokenSlice) Words() TokenSlice {
 	return words
 }
 
+// FilterComments removes comment tokens.
+func (t TokenSlice) FilterComments() TokenSlice {
+	filtered := make(TokenSlice, 0)
+	for _, token := range t {
+		if token.Type != COMMENT_TOKEN {
+			filtered = append(filtered, token)
+		}
+	}
+	return filtered
+}
+
+// Comments returns only the comment tokens.
+func (t TokenSlice) Comments() TokenSlice {
+	comments := make(TokenSlice, 0)
+	for _, token := range t {
+		if token.Type == COMMENT_TOKEN {
+			comments = append(comments, token)
+		}
+	}
+	return comments
+}
+
 func (t TokenSlice) FilterRedirects() TokenSlice {
 	filtered := make(TokenSlice, 0)
+	target := false
 	for index, token := range t {
-		switch token.Type {
-		case WORDBREAK_TOKEN:
-			if wordbreakType(token).IsRedirect() {
-				continue
-			}
+		switch {
+		case token.isRedirect():
+			target = true
+			continue
+		case token.Type == HEREDOC_TOKEN:
+			target = false
+			continue
+		case target && token.isRedirectTarget() && (t[index-1].isRedirect() || t[index-1].Adjoins(&token)):
+			continue // the target word might consist of several adjoining tokens
 		}
+		target = false
+		filtered = append(filtered, token)
+	}
+	return filtered
+}
 
-		if index > 0 {
-			if wordbreakType(t[index-1]).IsRedirect() {
-				continue
-			}
+// isRedirectTarget checks whether the token can be part of a redirect target word.
+// Pipeline delimiters and further redirects end the target.
+func (t Token) isRedirectTarget() bool {
+	switch {
+	case t.Type != WORD_TOKEN && t.Type != WORDBREAK_TOKEN:
+		return false
+	case t.Type == WORDBREAK_TOKEN && (t.isRedirect() || t.WordbreakType.IsPipelineDelimiter()):
+		return false
+	default:
+		return true
+	}
+}
+
+// Redirect is a redirection operator along with its target.
+type Redirect struct {
+	Fd          int    // file descriptor being redirected (explicit or 0 for input and 1 for output)
+	Op          string // operator without the file descriptor (`>`, `>>`, `>&`, `&>`, `<&`, ...)
+	Target      string // target word (file, file descriptor, `-` or here-document delimiter)
+	TargetToken *Token // first token of the target word (nil if missing)
+}
+
+// Redirections extracts the redirections (`2>err.log`, `2>&1`, `&>file`, `<&-`, ...) from the tokens.
+func (t TokenSlice) Redirections() []Redirect {
+	redirects := make([]Redirect, 0)
+	for index, token := range t {
+		if !token.isRedirect() {
+			continue
 		}
 
-		if index < len(t)-1 {
-			next := t[index+1]
-			if token.adjoins(next) {
-				if _, err := strconv.Atoi(token.RawValue); err == nil {
-					if wordbreakType(t[index+1]).IsRedirect() {
-						continue
-					}
-				}
-			}
+		redirect := Redirect{Op: token.redirectOperator()}
+		if fd, err := strconv.Atoi(strings.TrimSuffix(token.RawValue, redirect.Op)); err == nil {
+			redirect.Fd = fd
+		} else if !strings.HasPrefix(redirect.Op, "<") {
+			redirect.Fd = 1
+		}
 
+		for i := index + 1; i < len(t); i++ {
+			next := t[i]
+			if !next.isRedirectTarget() || i > index+1 && !t[i-1].Adjoins(&next) {
+				break // the target word might consist of several adjoining tokens
+			}
+			if redirect.TargetToken == nil {
+				redirect.TargetToken = &t[i]
+			}
+			redirect.Target += next.Value
 		}
+		redirects = append(redirects, redirect)
+	}
+	return redirects
+}
 
-		filtered = append(filtered, token)
+// Gap returns the original text between token i and i+1 (skipped spaces and comments).
+func (t TokenSlice) Gap(i int) string {
+	if i < 0 || i+1 >= len(t) {
+		return ""
 	}
-	return filtered
+	return t[i+1].Leading
 }
 
 func (t TokenSlice) CurrentToken() (token Token) {
@@ -92,32 +262,398 @@ func (t TokenSlice) CurrentToken() (token Token) {
 	return
 }
 
+// Reconstruct rebuilds the original input from the tokens.
+// It is lossless for tokens from Tokenize, whereas tokens from Split lack a trailing comment (`echo # comment`).
+func (t TokenSlice) Reconstruct() string {
+	var line strings.Builder
+	for _, token := range t {
+		line.WriteString(token.Leading)
+		line.WriteString(token.RawValue)
+	}
+	return line.String()
+}
+
+// ReplaceWord returns the line with the token at given index replaced by value,
+// which is quoted in the same style as the token (see Token.Requote).
+// Tokens from Words can be used as well.
+func (t TokenSlice) ReplaceWord(index int, value string) (string, error) {
+	if index < 0 || index >= len(t) {
+		return "", fmt.Errorf("index out of range: %v", index)
+	}
+
+	var line strings.Builder
+	for i, token := range t {
+		line.WriteString(token.Leading)
+		if i == index {
+			line.WriteString(token.Requote(value))
+		} else {
+			line.WriteString(token.RawValue)
+		}
+	}
+	return line.String(), nil
+}
+
+// LastWord returns the last word token (the one currently being completed).
+// It returns nil if there is none.
+func (t TokenSlice) LastWord() *Token {
+	for i := len(t) - 1; i >= 0; i-- {
+		if t[i].Type == WORD_TOKEN {
+			return &t[i]
+		}
+	}
+	return nil
+}
+
+// CurrentWordIndex returns the index of the current word within the words of the current pipeline.
+// It returns -1 if there is none.
+func (t TokenSlice) CurrentWordIndex() int {
+	if len(t) == 0 {
+		return -1
+	}
+	return len(t.CurrentPipeline().Words()) - 1
+}
+
+// TokenAt returns the token containing the cursor at given rune position.
+// A token contains positions from Index to EndIndex (inclusive), so on a boundary
+// between two adjoining tokens (`a|b`) the later one is returned.
+// A position past the last token returns the trailing empty token (`echo `) if present.
+// A position within skipped spaces or comments returns false.
+func (t TokenSlice) TokenAt(pos int) (*Token, bool) {
+	return t.tokenAt(pos, func(token Token) (int, int) { return token.Index, token.EndIndex })
+}
+
+// TokenAtByte is like TokenAt but with the cursor as byte offset (see ByteIndex).
+func (t TokenSlice) TokenAtByte(offset int) (*Token, bool) {
+	return t.tokenAt(offset, func(token Token) (int, int) { return token.ByteIndex, token.ByteEndIndex })
+}
+
+func (t TokenSlice) tokenAt(pos int, span func(Token) (int, int)) (*Token, bool) {
+	if len(t) == 0 {
+		return nil, false
+	}
+
+	if last := &t[len(t)-1]; last.RawValue == "" {
+		if start, _ := span(*last); pos >= start {
+			return last, true
+		}
+	}
+
+	for i := len(t) - 1; i >= 0; i-- {
+		if start, end := span(t[i]); start <= pos && pos <= end {
+			return &t[i], true
+		}
+	}
+	return nil, false
+}
+
+// EnvPrefix peels leading variable assignments (`GOOS=linux GOARCH=arm64 go build`) off the words of the current pipeline.
+// It returns the assigned variables along with the remaining words starting at the command.
+// The last word is never considered an assignment as it is the one currently being completed.
+func (t TokenSlice) EnvPrefix() (map[string]string, TokenSlice) {
+	return t.CurrentPipeline().Words().assignments()
+}
+
+// assignments peels leading variable assignments off the words.
+func (t TokenSlice) assignments() (map[string]string, TokenSlice) {
+	env := make(map[string]string)
+	words := t
+	for len(words) > 1 {
+		name := identifierPrefix(words[0].RawValue)
+		if name == "" || !strings.HasPrefix(words[0].RawValue[len(name):], "=") {
+			break
+		}
+		env[name] = words[0].Value[len(name)+1:]
+		words = words[1:]
+	}
+	return env, words
+}
+
+// Command returns the command word (skipping variable assignments and redirects).
+// It returns nil if there is none yet (e.g. `ls | `).
+// Use it on a single pipeline: `tokens.CurrentPipeline().Command()`.
+func (t TokenSlice) Command() *Token {
+	if _, words := t.FilterRedirects().Words().assignments(); len(words) > 0 && words[0].RawValue != "" {
+		return &words[0]
+	}
+	return nil
+}
+
+// Args returns the words following the command word.
+func (t TokenSlice) Args() TokenSlice {
+	if _, words := t.FilterRedirects().Words().assignments(); len(words) > 0 && words[0].RawValue != "" {
+		return words[1:]
+	}
+	return TokenSlice{}
+}
+
+// Arguments are the arguments of a command classified into flags and positionals.
+type Arguments struct {
+	Flags       TokenSlice
+	Positionals TokenSlice
+	Terminator  *Token // end-of-options marker (`--`)
+}
+
+// Flags classifies the arguments of the current pipeline into flags (`-a`, `--b`) and positionals.
+// Flags are detected by value so quoted ones (`"-n"`) are flags as well, while `-` (stdin) is a positional.
+// Arguments after the end-of-options marker (`--`) are positionals.
+func (t TokenSlice) Flags() Arguments {
+	args := Arguments{
+		Flags:       make(TokenSlice, 0),
+		Positionals: make(TokenSlice, 0),
+	}
+	words := t.CurrentPipeline().Args()
+	for index, word := range words {
+		switch {
+		case args.Terminator != nil:
+			args.Positionals = append(args.Positionals, word)
+		case word.Value == "--":
+			args.Terminator = &words[index]
+		case strings.HasPrefix(word.Value, "-") && word.Value != "-":
+			args.Flags = append(args.Flags, word)
+		default:
+			args.Positionals = append(args.Positionals, word)
+		}
+	}
+	return args
+}
+
+// dashDash returns the current pipeline and the index of its first unquoted `--` (-1 if none).
+// The last token is never considered as it is the one currently being completed.
+func (t TokenSlice) dashDash() (TokenSlice, int) {
+	pipeline := t.CurrentPipeline()
+	if len(pipeline) == 0 {
+		return pipeline, -1
+	}
+	for index, token := range pipeline[:len(pipeline)-1] {
+		if token.Type == WORD_TOKEN && token.RawValue == "--" {
+			return pipeline, index
+		}
+	}
+	return pipeline, -1
+}
+
+// AfterDashDash returns the tokens of the current pipeline after the end-of-options marker (`kubectl exec pod -- ls -la`).
+// It returns nil if there is none.
+func (t TokenSlice) AfterDashDash() TokenSlice {
+	if pipeline, index := t.dashDash(); index >= 0 {
+		return pipeline[index+1:]
+	}
+	return nil
+}
+
+// BeforeDashDash returns the tokens of the current pipeline before the end-of-options marker
+// (the whole pipeline if there is none).
+func (t TokenSlice) BeforeDashDash() TokenSlice {
+	if pipeline, index := t.dashDash(); index >= 0 {
+		return pipeline[:index]
+	}
+	return t.CurrentPipeline()
+}
+
+// StripPrecommand removes leading precommands with given names (`sudo -E systemctl restart`)
+// along with their flags and variable assignments, and returns the words starting at the effective command.
+// This is a heuristic: flags are words starting with `-` up to the first non-flag word (or `--`).
+// Flags taking a separate argument (`sudo -u root`) are unknown here, see StripPrecommandWith.
+// The last word is never removed as it is the one currently being completed.
+func (t TokenSlice) StripPrecommand(names ...string) TokenSlice {
+	precommands := make(map[string][]string, len(names))
+	for _, name := range names {
+		precommands[name] = nil
+	}
+	return t.StripPrecommandWith(precommands)
+}
+
+// StripPrecommandWith is like StripPrecommand, but takes the precommands along with
+// their flags which take a separate argument (`"sudo": {"-u", "--user"}` for `sudo -u root systemctl restart`).
+func (t TokenSlice) StripPrecommandWith(precommands map[string][]string) TokenSlice {
+	_, words := t.FilterRedirects().Words().assignments()
+	for len(words) > 1 {
+		argFlags, ok := precommands[words[0].Value]
+		if !ok {
+			break
+		}
+		words = words[1:]
+		for len(words) > 1 && strings.HasPrefix(words[0].Value, "-") {
+			flag := words[0].Value
+			words = words[1:]
+			if flag == "--" {
+				break
+			}
+			if contains(argFlags, flag) && len(words) > 1 {
+				words = words[1:]
+			}
+		}
+		_, words = words.assignments()
+	}
+	return words
+}
+
+// keywords contains the reserved words of the POSIX shell along with `time` (bash, zsh).
+var keywords = []string{"!", "{", "}", "case", "do", "done", "elif", "else", "esac", "fi", "for", "if", "in", "then", "time", "until", "while"}
+
+// IsKeyword checks whether given word is a reserved word of the shell (`if`, `do`, `!`, ...).
+func IsKeyword(s string) bool {
+	return contains(keywords, s)
+}
+
+// SkipKeywords peels leading reserved words off the words of the current pipeline and returns the words
+// starting at the command actually being run (`if systemctl sta` -> `systemctl sta`).
+// Loop syntax up to `do` (`for x in a b` + newline + `do`) and a case pattern up to `)` (`case $x in a) cmd`) are skipped as well,
+// as long as these are incomplete the keyword itself is the command. Variable assignments and redirects are removed like in Command.
+// Quoted words are no keywords and the last word is never removed as it is the one currently being completed.
+func (t TokenSlice) SkipKeywords() TokenSlice {
+	_, words := t.CurrentPipeline().FilterRedirects().Words().assignments()
+	for len(words) > 1 && words[0].Value == words[0].RawValue {
+		switch words[0].Value {
+		case "!", "{", "do", "elif", "else", "if", "then", "until", "while":
+			words = words[1:]
+		case "time":
+			words = words[1:]
+			if len(words) > 1 && words[0].Value == "-p" {
+				words = words[1:]
+			}
+		case "for":
+			index := words.keywordIndex(func(word Token) bool { return word.Value == "do" })
+			if index < 0 {
+				return words
+			}
+			words = words[index+1:]
+		case "case":
+			in := words.keywordIndex(func(word Token) bool { return word.Value == "in" })
+			index := words.keywordIndex(func(word Token) bool { return strings.HasSuffix(word.Value, ")") })
+			if in < 0 || index < in {
+				return words
+			}
+			words = words[index+1:]
+		default:
+			return words
+		}
+		_, words = words.assignments()
+	}
+	return words
+}
+
+// keywordIndex returns the index of the first unquoted word matching given function (-1 if none).
+// The last word is never considered as it is the one currently being completed.
+func (t TokenSlice) keywordIndex(f func(Token) bool) int {
+	for index, word := range t[:len(t)-1] {
+		if word.Value == word.RawValue && f(word) {
+			return index
+		}
+	}
+	return -1
+}
+
+func contains(s []string, e string) bool {
+	for _, v := range s {
+		if v == e {
+			return true
+		}
+	}
+	return false
+}
+
+// WordbreakPrefix returns the prefix of the current word up to the last wordbreak
+// (COMP_WORDBREAKS environment variable or BASH_WORDBREAKS).
 func (t TokenSlice) WordbreakPrefix() string {
+	return t.WordbreakPrefixWith(wordbreaks())
+}
+
+// WordbreakPrefixWith returns the prefix of the current word up to the last wordbreak of given runes.
+// Only wordbreaks the tokens were split at are considered (see WithWordbreaks).
+// Quoted and escaped wordbreak runes are part of a word token and thus never considered a wordbreak.
+func (t TokenSlice) WordbreakPrefixWith(breaks string) string {
+	prefix, _ := t.wordbreakSplit(breaks)
+	return prefix
+}
+
+// WordbreakSuffix returns the part of the current word after WordbreakPrefix,
+// which is what is actually completed in bash.
+func (t TokenSlice) WordbreakSuffix() string {
+	return t.WordbreakSuffixWith(wordbreaks())
+}
+
+// WordbreakSuffixWith returns the part of the current word after WordbreakPrefixWith.
+func (t TokenSlice) WordbreakSuffixWith(breaks string) string {
+	_, suffix := t.wordbreakSplit(breaks)
+	return suffix
+}
+
+// EndState returns the state of the tokenizer at the end of the input, which is the state of the last token.
+// PIPELINE_STATE is returned when the input ends after a pipeline delimiter (`echo a | `),
+// so the next word starts a new command.
+func (t TokenSlice) EndState() LexerState {
+	if len(t) == 0 {
+		return START_STATE
+	}
+	last := t[len(t)-1]
+	if len(t) > 1 && last.Type == WORD_TOKEN && last.RawValue == "" && t[len(t)-2].WordbreakType.IsPipelineDelimiter() {
+		return PIPELINE_STATE
+	}
+	return last.State
+}
+
+// ClosingSuffix returns the runes to append to make the current token well-formed (see Token.ClosingSuffix).
+func (t TokenSlice) ClosingSuffix() string {
+	return t.CurrentToken().ClosingSuffix()
+}
+
+// CurrentWordParts splits the current word at given wordbreak runes, like bash does with COMP_WORDBREAKS
+// for the word being completed (`dd if=/tmp/f` -> `if`, `=`, `/tmp/f`).
+// Quoted and escaped wordbreak runes don't split the word. The parts keep their position in the original input
+// and the last one is the part to complete (empty if the word ends with a wordbreak).
+func (t TokenSlice) CurrentWordParts(breaks string) TokenSlice {
+	words := t.CurrentPipeline().Words()
+	if len(words) == 0 {
+		return words
+	}
+
+	word := words[len(words)-1]
+	parts, err := Split(word.RawValue, WithWordbreaks(breaks), WithComments(false))
+	if err != nil {
+		return TokenSlice{word}
+	}
+	for index := range parts {
+		parts[index].Index += word.Index
+		parts[index].EndIndex += word.Index
+		parts[index].ByteIndex += word.ByteIndex
+		parts[index].ByteEndIndex += word.ByteIndex
+	}
+	parts[0].Leading = word.Leading
+	return parts
+}
+
+// wordbreakSplit splits the value of the current word at the last wordbreak of given runes.
+func (t TokenSlice) wordbreakSplit(breaks string) (prefix, suffix string) {
 	found := false
-	prefix := ""
 
 	last := t[len(t)-1]
 	switch last.State {
-	case QUOTING_STATE, QUOTING_ESCAPING_STATE, ESCAPING_QUOTED_STATE:
+	case QUOTING_STATE, QUOTING_ESCAPING_STATE, ESCAPING_QUOTED_STATE, ANSI_C_QUOTING_STATE, ESCAPING_ANSI_C_STATE:
 		// Seems bash handles the last opening quote as wordbreak when in quoting state.
 		// So add value up to last opening quote to prefix.
 		found = true
 		prefix = last.Value[:last.WordbreakIndex]
+		suffix = last.Value[last.WordbreakIndex:]
+	default:
+		suffix = last.Value
 	}
 
 	for i := len(t) - 2; i >= 0; i-- {
 		token := t[i]
-		if !token.adjoins(t[i+1]) {
+		if !token.Adjoins(&t[i+1]) {
 			break
 		}
 
-		if token.Type == WORDBREAK_TOKEN {
+		if token.Type == WORDBREAK_TOKEN && strings.ContainsAny(token.Value, breaks) {
 			found = true
 		}
 
 		if found {
 			prefix = token.Value + prefix
+		} else {
+			suffix = token.Value + suffix
 		}
 	}
-	return prefix
+	return prefix, suffix
 }
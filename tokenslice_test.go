@@ -0,0 +1,1083 @@
+package shlex
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFilterRedirects(t *testing.T) {
+	tests := map[string][]string{
+		"echo foo > bar baz":   {"echo", "foo", "baz"},
+		"echo foo >bar baz":    {"echo", "foo", "baz"},
+		"echo foo >| bar baz":  {"echo", "foo", "baz"},
+		"cat << EOF foo":       {"cat", "foo"},
+		"cat < in > out foo":   {"cat", "foo"},
+		"echo foo 2> err baz":  {"echo", "foo", "baz"},
+		"echo foo &>> log baz": {"echo", "foo", "baz"},
+		"echo foo > a=b baz":   {"echo", "foo", "baz"},
+		"echo 2>err.log foo":   {"echo", "foo"},
+		"cmd 2>&1 foo":         {"cmd", "foo"},
+		"cmd 12 >out foo":      {"cmd", "12", "foo"},
+		"echo >a|wc":           {"echo", "|", "wc"},
+		"echo >a;ls":           {"echo", ";", "ls"},
+		"echo 2>a&&ls":         {"echo", "&&", "ls"},
+
+		"grep foo <<< \"$input with spaces\" bar": {"grep", "foo", "bar"},
+		"grep foo <<<\"a b\"'c d' bar":            {"grep", "foo", "bar"},
+		"grep foo <<< 'a b' | wc -l":              {"grep", "foo", "|", "wc", "-l"},
+		"grep foo <<< ":                           {"grep", "foo"},
+	}
+	for s, want := range tests {
+		tokens, err := Split(s)
+		if err != nil {
+			t.Error(err)
+		}
+		clone := tokens.Clone()
+		if got := tokens.FilterRedirects().Strings(); !reflect.DeepEqual(got, want) {
+			t.Errorf("FilterRedirects(%q) -> %#v. Want: %#v", s, got, want)
+		}
+		if !tokens.Equal(clone) {
+			t.Errorf("FilterRedirects(%q) modified tokens \nGot : %#v\nWant: %#v", s, tokens, clone)
+		}
+	}
+}
+
+func TestFilterPositions(t *testing.T) {
+	s := `FOO=1 git -c a="b c" commit 2>/dev/null -m 'ä'"ö" -- x | tee >log "äö`
+	tokens, err := Split(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	original := tokens.Clone()
+
+	runes := []rune(s)
+	first := tokens.Pipelines()[0]
+	_, command := first.EnvPrefix()
+	filters := map[string]TokenSlice{
+		"Words":                 tokens.Words(),
+		"Words (again)":         tokens.Words(),
+		"FilterRedirects":       tokens.FilterRedirects(),
+		"CurrentPipeline":       tokens.CurrentPipeline(),
+		"CurrentPipeline.Words": tokens.CurrentPipeline().Words(),
+		"FilterRedirects.Words": first.FilterRedirects().Words(),
+		"Args":                  first.Args(),
+		"AfterDashDash":         first.AfterDashDash(),
+		"EnvPrefix":             command,
+	}
+	for name, filtered := range filters {
+		for _, token := range filtered {
+			if got := string(runes[token.Index:token.EndIndex]); got != token.RawValue {
+				t.Errorf("%v: input at indexes of %#v is %q", name, token, got)
+			}
+			if got := s[token.ByteIndex:token.ByteEndIndex]; got != token.RawValue {
+				t.Errorf("%v: input at byte offsets of %#v is %q", name, token, got)
+			}
+		}
+	}
+	if words := tokens.Words(); words[7].Value != "äö" || words[7].RawOffset(1) != 4 {
+		t.Errorf("Words() -> %#v", words[7])
+	}
+	if !tokens.Equal(original) || !reflect.DeepEqual(tokens, original) {
+		t.Errorf("filters modified the tokens \nGot : %#v\nWant: %#v", tokens, original)
+	}
+}
+
+func TestClone(t *testing.T) {
+	tokens, err := Split(`echo "$HOME/a" b`)
+	if err != nil {
+		t.Error(err)
+	}
+	clone := tokens.Clone()
+	if !clone.Equal(tokens) {
+		t.Errorf("Clone() \nGot : %#v\nWant: %#v", clone, tokens)
+	}
+
+	clone[1].Expansions[0].Name = "USER"
+	clone[1].RawOffsets[0] = 99
+	if tokens[1].Expansions[0].Name != "HOME" || tokens[1].RawOffsets[0] != 1 {
+		t.Errorf("Clone() shares memory: %#v", tokens[1])
+	}
+	if clone.Equal(tokens) {
+		t.Error("Equal() should fail for different expansions")
+	}
+	if clone.Equal(tokens[:2]) {
+		t.Error("Equal() should fail for different length")
+	}
+	if !(TokenSlice{}).Equal(nil) || (TokenSlice(nil)).Clone() != nil {
+		t.Error("empty slices should be equal")
+	}
+}
+
+func TestRedirectWordbreakType(t *testing.T) {
+	tests := map[string]WordbreakType{
+		"<":   WORDBREAK_REDIRECT_INPUT,
+		">":   WORDBREAK_REDIRECT_OUTPUT,
+		">>":  WORDBREAK_REDIRECT_OUTPUT_APPEND,
+		"<<":  WORDBREAK_REDIRECT_INPUT_HEREDOC,
+		">|":  WORDBREAK_REDIRECT_OUTPUT_CLOBBER,
+		"&>":  WORDBREAK_REDIRECT_OUTPUT_BOTH,
+		"<<<": WORDBREAK_REDIRECT_INPUT_STRING,
+	}
+	for operator, want := range tests {
+		s := "cmd " + operator + " target"
+		tokens, err := Split(s)
+		if err != nil {
+			t.Error(err)
+		}
+		token := tokens[1]
+		if token.Type != WORDBREAK_TOKEN || token.Value != operator || token.Index != 4 || token.WordbreakType != want {
+			t.Errorf("Split(%q)[1] -> %#v. Want: %v", s, token, want)
+		}
+	}
+}
+
+func TestPipelineOperatorWordbreakType(t *testing.T) {
+	tests := map[string]WordbreakType{
+		"|":   WORDBREAK_PIPE,
+		"|&":  WORDBREAK_PIPE_WITH_STDERR,
+		"||":  WORDBREAK_LIST_OR,
+		"&":   WORDBREAK_LIST_ASYNC,
+		"&&":  WORDBREAK_LIST_AND,
+		";":   WORDBREAK_LIST_SEQUENTIAL,
+		";;":  WORDBREAK_CASE_BREAK,
+		";&":  WORDBREAK_CASE_FALLTHROUGH,
+		";;&": WORDBREAK_CASE_CONTINUE,
+	}
+	for operator, want := range tests {
+		s := "a" + operator + "b"
+		tokens, err := Split(s)
+		if err != nil {
+			t.Error(err)
+		}
+		if len(tokens) != 3 {
+			t.Errorf("Split(%q) -> %#v. Want: 3 tokens", s, tokens.Strings())
+			continue
+		}
+		token := tokens[1]
+		if token.Type != WORDBREAK_TOKEN || token.Value != operator || token.Index != 1 || token.WordbreakType != want {
+			t.Errorf("Split(%q)[1] -> %#v. Want: %v", s, token, want)
+		}
+		if isCaseTerminator := strings.HasPrefix(operator, ";") && operator != ";"; !want.IsPipelineDelimiter() || want.IsCaseTerminator() != isCaseTerminator {
+			t.Errorf("%v.IsCaseTerminator() -> %v. Want: %v", want, want.IsCaseTerminator(), isCaseTerminator)
+		}
+	}
+
+	for s, want := range map[string][]string{
+		"a|&&b":  {"a", "|&", "&", "b"},
+		"a| &b":  {"a", "|", "&", "b"},
+		"a;;;b":  {"a", ";;", ";", "b"},
+		"a&&&b":  {"a", "&&", "&", "b"},
+		"a|>out": {"a", "|", ">", "out"},
+		"a&>>b":  {"a", "&>>", "b"},
+	} {
+		tokens, err := Split(s)
+		if err != nil {
+			t.Error(err)
+		}
+		if got := tokens.Strings(); !reflect.DeepEqual(got, want) {
+			t.Errorf("Split(%q) -> %#v. Want: %#v", s, got, want)
+		}
+	}
+}
+
+func TestPipelineDelimiters(t *testing.T) {
+	s := "a && b | c; d &"
+	tokens, err := Split(s)
+	if err != nil {
+		t.Error(err)
+	}
+
+	delimiters := make([]WordbreakType, 0)
+	for _, token := range tokens {
+		if token.Type == WORDBREAK_TOKEN {
+			delimiters = append(delimiters, token.WordbreakType)
+		}
+	}
+	wantDelimiters := []WordbreakType{WORDBREAK_LIST_AND, WORDBREAK_PIPE, WORDBREAK_LIST_SEQUENTIAL, WORDBREAK_LIST_ASYNC}
+	if !reflect.DeepEqual(delimiters, wantDelimiters) {
+		t.Errorf("Split(%q) delimiters -> %v. Want: %v", s, delimiters, wantDelimiters)
+	}
+
+	pipelines := make([][]string, 0)
+	for _, pipeline := range tokens.Pipelines() {
+		pipelines = append(pipelines, pipeline.Strings())
+	}
+	wantPipelines := [][]string{{"a"}, {"b"}, {"c"}, {"d"}, {""}}
+	if !reflect.DeepEqual(pipelines, wantPipelines) {
+		t.Errorf("Split(%q).Pipelines() -> %#v. Want: %#v", s, pipelines, wantPipelines)
+	}
+
+	for s, want := range map[string][][]string{
+		`git add . && git commit -m "x" | tee log`: {{"git", "add", "."}, {"git", "commit", "-m", "x"}, {"tee", "log"}},
+		"a;;b":   {{"a"}, {"b"}},
+		"a; ;b":  {{"a"}, {}, {"b"}},
+		"a;; ;b": {{"a"}, {}, {"b"}},
+		"a; & b": {{"a"}, {}, {"b"}},
+		";a":     {{}, {"a"}},
+		"a|&b":   {{"a"}, {"b"}},
+		"a;&b":   {{"a"}, {"b"}},
+		"a;;&b":  {{"a"}, {"b"}},
+		"a|&&b":  {{"a"}, {}, {"b"}},
+	} {
+		tokens, err := Split(s)
+		if err != nil {
+			t.Error(err)
+		}
+		pipelines := make([][]string, 0)
+		for _, pipeline := range tokens.Pipelines() {
+			pipelines = append(pipelines, pipeline.Strings())
+		}
+		if !reflect.DeepEqual(pipelines, want) {
+			t.Errorf("Split(%q).Pipelines() -> %#v. Want: %#v", s, pipelines, want)
+		}
+	}
+
+	tests := map[string][]string{
+		"a;;b":           {"b"},
+		"a && b | c; d":  {"d"},
+		"a && b | c; d ": {"d", ""},
+		"a && b | c":     {"c"},
+		"a && b":         {"b"},
+		"a > out && b":   {"b"},
+		"a &":            {""},
+	}
+	for s, want := range tests {
+		tokens, err := Split(s)
+		if err != nil {
+			t.Error(err)
+		}
+		pipeline := tokens.CurrentPipeline()
+		if got := pipeline.Strings(); !reflect.DeepEqual(got, want) {
+			t.Errorf("Split(%q).CurrentPipeline() -> %#v. Want: %#v", s, got, want)
+		}
+		if suffix := tokens[len(tokens)-len(pipeline):]; !pipeline.Equal(suffix) {
+			t.Errorf("Split(%q).CurrentPipeline() \nGot : %#v\nWant: %#v", s, pipeline, suffix)
+		}
+	}
+
+	for _, s := range []string{
+		"a |", "a | ",
+		"a &&", "a && ",
+		"a ;", "a ; ",
+		"a ||", "a || ",
+		"a &", "a & ",
+		"a;", "a |\n",
+		"ls | grep foo &&", "ls | grep foo && ",
+	} {
+		tokens, err := Split(s)
+		if err != nil {
+			t.Error(err)
+		}
+		pipeline := tokens.CurrentPipeline()
+		if len(pipeline) != 1 || pipeline[0].Type != WORD_TOKEN || pipeline[0].RawValue != "" || pipeline[0].Index != len(s) {
+			t.Errorf("Split(%q).CurrentPipeline() should only contain an empty word at the end: %#v", s, pipeline)
+		}
+	}
+}
+
+func TestGroups(t *testing.T) {
+	tests := []struct {
+		input   string
+		groups  []string
+		current []string
+	}{
+		{"(cd /tmp && ls) | wc -l", []string{"(", ")"}, []string{"wc", "-l"}},
+		{"{ echo a; echo b; }", []string{"{", "}"}, []string{""}},
+		{"{ echo a; echo b", []string{"{"}, []string{"echo", "b"}},
+		{"{ echo a\n}", []string{"{", "}"}, []string{""}},
+		{"{ echo a # }\n}", []string{"{", "}"}, []string{""}},
+		{"{ echo a \\\n}", []string{"{"}, []string{"echo", "a", "}"}},
+		{"(cd /tmp && ls", []string{"("}, []string{"ls"}},
+		{"(cd /tm", []string{"("}, []string{"cd", "/tm"}},
+		{"a && (b; (c | d", []string{"(", "("}, []string{"d"}},
+		{"(", []string{"("}, []string{""}},
+		{"( ", []string{"("}, []string{""}},
+		{"echo foo(bar)", []string{}, []string{"echo", "foo", "(", "bar)"}},
+		{"echo a{b,c} { }", []string{}, []string{"echo", "a{b,c}", "{", "}"}},
+		{"{a,b} c", []string{}, []string{"{a,b}", "c"}},
+		{"echo (a)", []string{}, []string{"echo", "(", "a)"}},
+	}
+	for _, test := range tests {
+		tokens, err := Split(test.input)
+		if err != nil {
+			t.Error(err)
+		}
+		groups := make([]string, 0)
+		for _, token := range tokens {
+			if token.Type == GROUP_TOKEN {
+				groups = append(groups, token.Value)
+			}
+		}
+		if !reflect.DeepEqual(groups, test.groups) {
+			t.Errorf("Split(%q) groups -> %#v. Want: %#v", test.input, groups, test.groups)
+		}
+		if got := tokens.CurrentPipeline().Strings(); !reflect.DeepEqual(got, test.current) {
+			t.Errorf("Split(%q).CurrentPipeline() -> %#v. Want: %#v", test.input, got, test.current)
+		}
+	}
+
+	tokens, err := Split("echo foo(bar)")
+	if err != nil {
+		t.Error(err)
+	}
+	if got, want := tokens.Words().Strings(), []string{"echo", "foo(bar)"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Words() -> %#v. Want: %#v", got, want)
+	}
+}
+
+func TestRedirections(t *testing.T) {
+	tests := map[string][]Redirect{
+		"echo 2>err.log foo": {{Fd: 2, Op: ">", Target: "err.log"}},
+		"cmd 2>>log":         {{Fd: 2, Op: ">>", Target: "log"}},
+		"cmd >&2":            {{Fd: 1, Op: ">&", Target: "2"}},
+		"cmd 2>&1 | less":    {{Fd: 2, Op: ">&", Target: "1"}},
+		"cmd &>file":         {{Fd: 1, Op: "&>", Target: "file"}},
+		"cmd <&-":            {{Fd: 0, Op: "<&", Target: "-"}},
+		"cmd < in 1>>a=b":    {{Fd: 0, Op: "<", Target: "in"}, {Fd: 1, Op: ">>", Target: "a=b"}},
+		"cmd >":              {{Fd: 1, Op: ">", Target: ""}},
+		"cmd 2 > out":        {{Fd: 1, Op: ">", Target: "out"}},
+		"cmd":                {},
+	}
+	for s, want := range tests {
+		tokens, err := Split(s)
+		if err != nil {
+			t.Error(err)
+		}
+		got := tokens.Redirections()
+		for index := range got {
+			if got[index].TargetToken == nil {
+				t.Errorf("Split(%q).Redirections()[%v].TargetToken is nil", s, index)
+			}
+			got[index].TargetToken = nil
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Split(%q).Redirections() -> %#v. Want: %#v", s, got, want)
+		}
+	}
+
+	tokens, err := Split("cmd 2>&1")
+	if err != nil {
+		t.Error(err)
+	}
+	if got := tokens.Strings(); !reflect.DeepEqual(got, []string{"cmd", "2>&", "1"}) {
+		t.Errorf("Split(%q) -> %#v", "cmd 2>&1", got)
+	}
+	if redirect := tokens.Redirections()[0]; redirect.TargetToken != &tokens[2] {
+		t.Errorf("Split(%q).Redirections()[0].TargetToken -> %#v. Want: %#v", "cmd 2>&1", redirect.TargetToken, tokens[2])
+	}
+}
+
+func TestPipelineRunes(t *testing.T) {
+	tests := []struct {
+		input   string
+		runes   string
+		current []string
+	}{
+		{"a | b; c", "|", []string{"b", ";", "c"}},
+		{"a || b && c", "|", []string{"b", "&&", "c"}},
+		{"a | b; c", ";", []string{"c"}},
+		{"a | b; c &", "", []string{"a", "|", "b", ";", "c", "&", ""}},
+		{"a 2>&1 | b", "|", []string{"b"}},
+	}
+	for _, test := range tests {
+		tokens, err := Split(test.input, WithPipelineRunes(test.runes))
+		if err != nil {
+			t.Error(err)
+		}
+		if got := tokens.CurrentPipeline().Strings(); !reflect.DeepEqual(got, test.current) {
+			t.Errorf("Split(%q).CurrentPipeline() -> %#v. Want: %#v", test.input, got, test.current)
+		}
+	}
+
+	tokens, err := Split("a 2>&1 ; b", WithPipelineRunes(""))
+	if err != nil {
+		t.Error(err)
+	}
+	if got := tokens.FilterRedirects().Strings(); !reflect.DeepEqual(got, []string{"a", ";", "b"}) {
+		t.Errorf("Split(%q).FilterRedirects() -> %#v", "a 2>&1 ; b", got)
+	}
+}
+
+func TestWordbreakTypeValues(t *testing.T) {
+	// values are public API, new types are added at the end
+	tests := map[WordbreakType]int{
+		WORDBREAK_REDIRECT_INPUT_OUTPUT: 8,
+		WORDBREAK_PIPE:                  9,
+		WORDBREAK_LIST_OR:               14,
+		WORDBREAK_CUSTOM:                15,
+	}
+	for wordbreakType, want := range tests {
+		if int(wordbreakType) != want {
+			t.Errorf("%v -> %v. Want: %v", wordbreakTypes[wordbreakType], int(wordbreakType), want)
+		}
+	}
+}
+
+func TestWordbreakPrefix(t *testing.T) {
+	withoutColon := strings.Replace(BASH_WORDBREAKS, ":", "", 1)
+	tests := []struct {
+		input  string
+		opts   []Option
+		breaks string
+		want   string
+		suffix string
+	}{
+		{"scp host:/path", nil, BASH_WORDBREAKS, "host:", "/path"},
+		{"scp host:/path", nil, withoutColon, "", "host:/path"},
+		{"scp host:/path", []Option{WithWordbreaks(withoutColon)}, BASH_WORDBREAKS, "", "host:/path"},
+		{"scp user@host:/path", []Option{WithWordbreaks(BASH_WORDBREAKS + "@")}, BASH_WORDBREAKS + "@", "user@host:", "/path"},
+		{"scp user@host:/path", []Option{WithWordbreaks(BASH_WORDBREAKS + "@")}, "@", "user@", "host:/path"},
+		{"git --opt=a:b", nil, withoutColon, "--opt=", "a:b"},
+		{`echo "a:b`, nil, withoutColon, "", "a:b"},
+		{`echo "a:b`, nil, BASH_WORDBREAKS, "", "a:b"},
+		{`cmd --flag="/path/with spaces/`, nil, BASH_WORDBREAKS, "--flag=", "/path/with spaces/"},
+		{`cmd --flag="a:b=c`, nil, BASH_WORDBREAKS, "--flag=", "a:b=c"},
+		{`ssh host\:port`, nil, BASH_WORDBREAKS, "", "host:port"},
+		{`cmd a\=b:c`, nil, BASH_WORDBREAKS, "a=b:", "c"},
+		{`cmd 'a:b'=c:d`, nil, BASH_WORDBREAKS, "a:b=c:", "d"},
+		{`cmd 'a:b'=c:d`, nil, "=", "a:b=", "c:d"},
+		{"scp host:", nil, BASH_WORDBREAKS, "host:", ""},
+	}
+	for _, test := range tests {
+		tokens, err := Split(test.input, test.opts...)
+		if err != nil {
+			t.Error(err)
+		}
+		if got := tokens.WordbreakPrefixWith(test.breaks); got != test.want {
+			t.Errorf("Split(%q).WordbreakPrefixWith(%q) -> %q. Want: %q", test.input, test.breaks, got, test.want)
+		}
+		if got := tokens.WordbreakSuffixWith(test.breaks); got != test.suffix {
+			t.Errorf("Split(%q).WordbreakSuffixWith(%q) -> %q. Want: %q", test.input, test.breaks, got, test.suffix)
+		}
+	}
+
+	tokens, err := Split("scp host:/path")
+	if err != nil {
+		t.Error(err)
+	}
+	if got := tokens.WordbreakPrefix(); got != "host:" {
+		t.Errorf("Split(%q).WordbreakPrefix() -> %q. Want: %q", "scp host:/path", got, "host:")
+	}
+}
+
+func TestEndState(t *testing.T) {
+	tests := map[string]LexerState{
+		``:           START_STATE,
+		`echo `:      START_STATE,
+		`echo a`:     IN_WORD_STATE,
+		`echo 'a`:    QUOTING_STATE,
+		`echo "a`:    QUOTING_ESCAPING_STATE,
+		`echo a |`:   PIPELINE_STATE,
+		`echo a | `:  PIPELINE_STATE,
+		`echo a && `: PIPELINE_STATE,
+		`echo a; `:   PIPELINE_STATE,
+		`echo a |b`:  IN_WORD_STATE,
+		`echo a >`:   START_STATE,
+		`(`:          START_STATE,
+	}
+	for s, want := range tests {
+		tokens, err := Split(s)
+		if err != nil {
+			t.Error(err)
+		}
+		if got := tokens.EndState(); got != want {
+			t.Errorf("Split(%q).EndState() -> %v. Want: %v", s, got, want)
+		}
+	}
+}
+
+func TestClosingSuffix(t *testing.T) {
+	tests := map[string]string{
+		`echo a`:          "",
+		`echo `:           "",
+		`echo "a 'b`:      `"`,
+		`echo 'a "b`:      "'",
+		`echo $"a`:        `"`,
+		`echo $'a`:        "'",
+		`echo $'a\`:       `\'`,
+		`echo "a\`:        `\"`,
+		`echo a\`:         `\`,
+		"echo `a":         "`",
+		"echo `a\\":       "\\`",
+		`echo $(a`:        ")",
+		`echo $(a "b`:     `")`,
+		`echo $(a $(b '(`: "'))",
+		`echo $((1+`:      "))",
+		`echo <(a`:        ")",
+		`echo $(a \`:      `\)`,
+		`echo "$(a 'b`:    `')"`,
+	}
+	for s, want := range tests {
+		tokens, err := Split(s)
+		if err != nil {
+			t.Error(err)
+		}
+		got := tokens.ClosingSuffix()
+		if got != want {
+			t.Errorf("Split(%q).ClosingSuffix() -> %q. Want: %q", s, got, want)
+		}
+		if tokens, err := Split(s+got, WithStrict(true)); want != "" && (err != nil || tokens.CurrentToken().State != IN_WORD_STATE) {
+			t.Errorf("Split(%q) should be well-formed -> %v, %v", s+got, err, tokens.CurrentToken().State)
+		}
+	}
+}
+
+func TestCurrentWordParts(t *testing.T) {
+	tests := []struct {
+		input  string
+		breaks string
+		want   []string
+	}{
+		{"dd if=/tmp/f", BASH_WORDBREAKS, []string{"if", "=", "/tmp/f"}},
+		{"dd if=", BASH_WORDBREAKS, []string{"if", "=", ""}},
+		{"dd if=/tmp/f", ":", []string{"if=/tmp/f"}},
+		{"dd ", BASH_WORDBREAKS, []string{""}},
+		{"scp user@host:/path", "@:", []string{"user", "@", "host", ":", "/path"}},
+		{`ssh host\:port`, BASH_WORDBREAKS, []string{"host:port"}},
+		{`cmd --flag="a:b`, BASH_WORDBREAKS, []string{"--flag", "=", "a:b"}},
+		{"cmd a:#b", ":", []string{"a", ":", "#b"}},
+		{"ls | dd of=x", "=", []string{"of", "=", "x"}},
+	}
+	for _, test := range tests {
+		tokens, err := Split(test.input, WithWordbreaks(""))
+		if err != nil {
+			t.Error(err)
+		}
+		if got := tokens.CurrentWordParts(test.breaks).Strings(); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Split(%q).CurrentWordParts(%q) -> %#v. Want: %#v", test.input, test.breaks, got, test.want)
+		}
+	}
+
+	s := "dd  if=/tmp/ä"
+	tokens, _ := Split(s)
+	parts := tokens.CurrentWordParts(BASH_WORDBREAKS)
+	for _, part := range parts {
+		if got := s[part.ByteIndex:part.ByteEndIndex]; got != part.RawValue {
+			t.Errorf("input at byte offsets of %#v is %q", part, got)
+		}
+		if got := string([]rune(s)[part.Index:part.EndIndex]); got != part.RawValue {
+			t.Errorf("input at rune indexes of %#v is %q", part, got)
+		}
+	}
+	if parts[0].Leading != "  " || parts.Reconstruct() != "  if=/tmp/ä" {
+		t.Errorf("Reconstruct() of parts -> %q", parts.Reconstruct())
+	}
+}
+
+func TestGap(t *testing.T) {
+	tests := []string{
+		"",
+		"  echo  foo\tbar ",
+		"echo foo # comment\n\tls -l",
+		"cat <<EOF > out # comment\nbody\n  EOF\nEOF\n\necho done",
+		"(cd /tmp && ls) | { wc -l; } 2>&1",
+		`echo "a  b" 'c' \ d\`,
+		"äö=ü ß",
+	}
+	for _, s := range tests {
+		tokens, err := Split(s)
+		if err != nil {
+			t.Error(err)
+		}
+		reconstructed := tokens[0].Leading
+		for index, token := range tokens {
+			reconstructed += token.RawValue + tokens.Gap(index)
+		}
+		if reconstructed != s {
+			t.Errorf("Split(%q) reconstructed -> %q", s, reconstructed)
+		}
+	}
+
+	tokens, err := Split(`foo"bar" foo "bar" --flag=value # c`)
+	if err != nil {
+		t.Error(err)
+	}
+	if got, want := []string{tokens.Gap(-1), tokens.Gap(0), tokens.Gap(2), tokens.Gap(3), tokens.Gap(5)}, []string{"", " ", " ", "", ""}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Gap() -> %#v. Want: %#v", got, want)
+	}
+	if tokens[0].Adjoins(&tokens[1]) || !tokens[3].Adjoins(&tokens[4]) || !tokens[4].Adjoins(&tokens[3]) || !tokens[4].Adjoins(&tokens[5]) {
+		t.Errorf("Adjoins() of %#v", tokens)
+	}
+
+	if got := tokens.Words().Strings(); !reflect.DeepEqual(got, []string{"foobar", "foo", "bar", "--flag=value"}) {
+		t.Errorf("Words() -> %#v", got)
+	}
+	tokens, _ = Split("äö=ü ß")
+	if got := tokens.Words().Strings(); !reflect.DeepEqual(got, []string{"äö=ü", "ß"}) {
+		t.Errorf("Words() -> %#v", got)
+	}
+}
+
+func TestTokenAt(t *testing.T) {
+	tests := []struct {
+		s    string
+		pos  int
+		want string // RawValue of expected token, "-" for none
+	}{
+		{``, 0, ""},
+		{`echo`, 0, "echo"},
+		{`echo`, 2, "echo"},
+		{`echo`, 4, "echo"},
+		{`echo`, 5, "-"},
+		{`echo `, 4, "echo"},
+		{`echo `, 5, ""},
+		{`echo `, 9, ""},
+		{`echo  a`, 5, "-"},
+		{`echo  a`, 6, "a"},
+		{`echo  a`, 7, "a"},
+		{`a|b`, 0, "a"},
+		{`a|b`, 1, "|"},
+		{`a|b`, 2, "b"},
+		{`a|b`, 3, "b"},
+		{`a | b`, 1, "a"},
+		{`a | b`, 2, "|"},
+		{`a | b`, 3, "|"},
+		{`a | b`, 4, "b"},
+		{`a||b`, 1, "||"},
+		{`a||b`, 3, "b"},
+		{`echo "a b"`, 5, `"a b"`},
+		{`echo "a b"`, 7, `"a b"`},
+		{`echo "a b"`, 10, `"a b"`},
+		{`echo "a b`, 9, `"a b`},
+		{`echo 'a'"b"`, 5, `'a'"b"`},
+		{`echo 'a'"b"`, 8, `'a'"b"`},
+		{`echo a>b`, 6, ">"},
+		{`echo a>b`, 7, "b"},
+		{`echo # comment`, 8, "-"},
+	}
+	for _, test := range tests {
+		tokens, err := Split(test.s)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		got := "-"
+		if token, ok := tokens.TokenAt(test.pos); ok {
+			got = token.RawValue
+		}
+		if got != test.want {
+			t.Errorf("Split(%#v).TokenAt(%v) -> %#v. Want: %#v", test.s, test.pos, got, test.want)
+		}
+	}
+
+	tokens, _ := Split(`échö "ä b" c`)
+	for offset, want := range map[int]string{0: "échö", 6: "échö", 7: `"ä b"`, 9: `"ä b"`, 13: `"ä b"`, 14: "c"} {
+		got := "-"
+		if token, ok := tokens.TokenAtByte(offset); ok {
+			got = token.RawValue
+		}
+		if got != want {
+			t.Errorf("TokenAtByte(%v) -> %#v. Want: %#v", offset, got, want)
+		}
+	}
+}
+
+func TestFilterComments(t *testing.T) {
+	s := "echo a # first\necho b #second"
+	tokens, err := Tokenize(s)
+	if err != nil {
+		t.Error(err)
+	}
+	if got, want := tokens.Comments().Strings(), []string{" first", "second"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Tokenize(%q).Comments() -> %#v. Want: %#v", s, got, want)
+	}
+
+	split, err := Split(s)
+	if err != nil {
+		t.Error(err)
+	}
+	if got, want := tokens.FilterComments().Strings(), split.Strings(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Tokenize(%q).FilterComments() -> %#v. Want: %#v", s, got, want)
+	}
+	if comments := split.Comments(); len(comments) != 0 {
+		t.Errorf("Split(%q).Comments() -> %#v", s, comments)
+	}
+}
+
+func TestEnvPrefix(t *testing.T) {
+	tests := []struct {
+		s    string
+		env  map[string]string
+		args []string
+	}{
+		{`GOOS=linux GOARCH=arm64 go build`, map[string]string{"GOOS": "linux", "GOARCH": "arm64"}, []string{"go", "build"}},
+		{`MSG="hello world" echo hi`, map[string]string{"MSG": "hello world"}, []string{"echo", "hi"}},
+		{`A=b=c D= cmd`, map[string]string{"A": "b=c", "D": ""}, []string{"cmd"}},
+		{`ls && A=1 B='$x' env `, map[string]string{"A": "1", "B": "$x"}, []string{"env", ""}},
+		{`"A"=b cmd`, map[string]string{}, []string{"A=b", "cmd"}},
+		{`1A=b cmd`, map[string]string{}, []string{"1A=b", "cmd"}},
+		{`cmd A=b`, map[string]string{}, []string{"cmd", "A=b"}},
+		{`A=b`, map[string]string{}, []string{"A=b"}},
+	}
+	for _, test := range tests {
+		tokens, err := Split(test.s)
+		if err != nil {
+			t.Error(err)
+		}
+		env, args := tokens.EnvPrefix()
+		if !reflect.DeepEqual(env, test.env) || !reflect.DeepEqual(args.Strings(), test.args) {
+			t.Errorf("Split(%q).EnvPrefix() -> %#v, %#v. Want: %#v, %#v", test.s, env, args.Strings(), test.env, test.args)
+		}
+	}
+}
+
+func TestCommand(t *testing.T) {
+	tests := []struct {
+		s       string
+		command string // "-" for none
+		args    []string
+	}{
+		{`ls -la`, "ls", []string{"-la"}},
+		{`>out ls -la`, "ls", []string{"-la"}},
+		{`2>/dev/null <in ls -la >out`, "ls", []string{"-la"}},
+		{`A=1 >out B=2 ls -la `, "ls", []string{"-la", ""}},
+		{`cat a | grep -v b`, "grep", []string{"-v", "b"}},
+		{`cat a |`, "-", []string{}},
+		{`cat a | `, "-", []string{}},
+		{`gi`, "gi", []string{}},
+		{``, "-", []string{}},
+	}
+	for _, test := range tests {
+		tokens, err := Split(test.s)
+		if err != nil {
+			t.Error(err)
+		}
+		pipeline := tokens.CurrentPipeline()
+		command := "-"
+		if token := pipeline.Command(); token != nil {
+			command = token.Value
+		}
+		if args := pipeline.Args().Strings(); command != test.command || !reflect.DeepEqual(args, test.args) {
+			t.Errorf("Split(%q).CurrentPipeline() -> %#v, %#v. Want: %#v, %#v", test.s, command, args, test.command, test.args)
+		}
+	}
+}
+
+func TestStripPrecommand(t *testing.T) {
+	precommands := map[string][]string{
+		"env":   {"-C", "-S", "-u", "--chdir", "--split-string", "--unset"},
+		"nice":  {"-n", "--adjustment"},
+		"sudo":  {"-C", "-D", "-g", "-h", "-p", "-R", "-r", "-T", "-t", "-U", "-u", "--chdir", "--group", "--host", "--prompt", "--role", "--type", "--user"},
+		"xargs": {"-a", "-d", "-E", "-I", "-L", "-n", "-P", "-s", "--arg-file", "--delimiter", "--max-args", "--max-procs"},
+	}
+	tests := map[string][]string{
+		`sudo -u root systemctl rest`:        {"systemctl", "rest"},
+		`sudo -uroot -E systemctl rest`:      {"systemctl", "rest"},
+		`sudo --user=root systemctl rest`:    {"systemctl", "rest"},
+		`sudo env FOO=1 cmd --flag`:          {"cmd", "--flag"},
+		`env VAR=x git `:                     {"git", ""},
+		`env -u HOME -- git st`:              {"git", "st"},
+		`find . | xargs -0 rm -f`:            {"rm", "-f"},
+		`xargs -0 rm -f`:                     {"rm", "-f"},
+		`xargs -n 1 -P 4 echo`:               {"echo"},
+		`FOO=1 nice -n 10 sudo make install`: {"make", "install"},
+		`sudo`:                               {"sudo"},
+		`sudo -`:                             {"-"},
+		`sudo -u `:                           {""},
+		`doas ls`:                            {"doas", "ls"},
+	}
+	for s, want := range tests {
+		tokens, err := Split(s)
+		if err != nil {
+			t.Error(err)
+		}
+		if got := tokens.CurrentPipeline().StripPrecommandWith(precommands).Strings(); !reflect.DeepEqual(got, want) {
+			t.Errorf("Split(%q).CurrentPipeline().StripPrecommandWith() -> %#v. Want: %#v", s, got, want)
+		}
+	}
+
+	tests = map[string][]string{
+		`sudo -E systemctl rest`:   {"systemctl", "rest"},
+		`sudo -u root systemctl `:  {"root", "systemctl", ""},
+		`sudo env FOO=1 cmd --fla`: {"cmd", "--fla"},
+		`nice make`:                {"nice", "make"},
+	}
+	for s, want := range tests {
+		tokens, err := Split(s)
+		if err != nil {
+			t.Error(err)
+		}
+		if got := tokens.CurrentPipeline().StripPrecommand("sudo", "env").Strings(); !reflect.DeepEqual(got, want) {
+			t.Errorf("Split(%q).CurrentPipeline().StripPrecommand() -> %#v. Want: %#v", s, got, want)
+		}
+	}
+}
+
+func TestSkipKeywords(t *testing.T) {
+	tests := map[string][]string{
+		`if systemctl sta`:                 {"systemctl", "sta"},
+		`if ! git diff --quiet`:            {"git", "diff", "--quiet"},
+		`while true; do sleep `:            {"sleep", ""},
+		`until FOO=1 make`:                 {"make"},
+		`time -p make -j`:                  {"make", "-j"},
+		`if true; then else ls`:            {"ls"},
+		`! { ls`:                           {"ls"},
+		"for x in a b\ndo echo $x":         {"echo", "$x"},
+		`for x in a b`:                     {"for", "x", "in", "a", "b"},
+		`case $x in a) echo a`:             {"echo", "a"},
+		`case $x in a`:                     {"case", "$x", "in", "a"},
+		`if 2>/dev/null grep -q x`:         {"grep", "-q", "x"},
+		`"if" git`:                         {"if", "git"},
+		`git if`:                           {"git", "if"},
+		`if`:                               {"if"},
+		`if `:                              {""},
+		`ls | if grep`:                     {"grep"},
+		`fi`:                               {"fi"},
+		`echo ok; done`:                    {"done"},
+		`time`:                             {"time"},
+		`while read -r line; do echo $lin`: {"echo", "$lin"},
+	}
+	for s, want := range tests {
+		tokens, err := Split(s)
+		if err != nil {
+			t.Error(err)
+		}
+		if got := tokens.SkipKeywords().Strings(); !reflect.DeepEqual(got, want) {
+			t.Errorf("Split(%q).SkipKeywords() -> %#v. Want: %#v", s, got, want)
+		}
+	}
+
+	for s, want := range map[string]bool{"if": true, "done": true, "!": true, "time": true, "iff": false, "": false, "ls": false} {
+		if got := IsKeyword(s); got != want {
+			t.Errorf("IsKeyword(%q) -> %v. Want: %v", s, got, want)
+		}
+	}
+}
+
+func TestStatements(t *testing.T) {
+	tests := map[string][][]string{
+		"a | b && c; d":     {{"a", "|", "b"}, {"c"}, {"d"}},
+		"a | b && c; d ":    {{"a", "|", "b"}, {"c"}, {"d", ""}},
+		"a |& b || c & ":    {{"a", "|&", "b"}, {"c"}, {""}},
+		"a\nb | c\n\nd":     {{"a"}, {"b", "|", "c"}, {"d"}},
+		"a # comment\nb":    {{"a"}, {"b"}},
+		"a;;b":              {{"a"}, {"b"}},
+		"a; ;b":             {{"a"}, {}, {"b"}},
+		"a \\\nb; c":        {{"a", "b"}, {"c"}},
+		"a | ":              {{"a", "|", ""}},
+		"(a | b) && c":      {{}, {"a", "|", "b"}, {}, {"c"}},
+		"cat <<EOF\nx\nEOF": {{"cat", "<<", "EOF", "x\n"}},
+	}
+	for s, want := range tests {
+		tokens, err := Split(s)
+		if err != nil {
+			t.Error(err)
+		}
+		statements := make([][]string, 0)
+		for _, statement := range tokens.Statements() {
+			statements = append(statements, statement.Strings())
+		}
+		if !reflect.DeepEqual(statements, want) {
+			t.Errorf("Split(%q).Statements() -> %#v. Want: %#v", s, statements, want)
+		}
+	}
+}
+
+func TestDashDash(t *testing.T) {
+	tests := []struct {
+		s      string
+		before []string
+		after  []string
+	}{
+		{`kubectl exec pod -- ls -la`, []string{"kubectl", "exec", "pod"}, []string{"ls", "-la"}},
+		{`kubectl exec pod -- `, []string{"kubectl", "exec", "pod"}, []string{""}},
+		{`kubectl exec pod --`, []string{"kubectl", "exec", "pod", "--"}, nil},
+		{`a -- b -- c`, []string{"a"}, []string{"b", "--", "c"}},
+		{`a "--" b`, []string{"a", "--", "b"}, nil},
+		{`a --flag b`, []string{"a", "--flag", "b"}, nil},
+		{`x -- y | a -- b`, []string{"a"}, []string{"b"}},
+		{`x -- y | a b`, []string{"a", "b"}, nil},
+	}
+	for _, test := range tests {
+		tokens, err := Split(test.s)
+		if err != nil {
+			t.Error(err)
+		}
+		var after []string
+		if tokens := tokens.AfterDashDash(); tokens != nil {
+			after = tokens.Strings()
+		}
+		if before := tokens.BeforeDashDash().Strings(); !reflect.DeepEqual(before, test.before) || !reflect.DeepEqual(after, test.after) {
+			t.Errorf("Split(%q) -> %#v, %#v. Want: %#v, %#v", test.s, before, after, test.before, test.after)
+		}
+	}
+
+	tokens, err := Tokenize("# only")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tokens := range []TokenSlice{{}, tokens.FilterComments()} {
+		if after := tokens.AfterDashDash(); after != nil {
+			t.Errorf("%#v.AfterDashDash() -> %#v. Want: nil", tokens, after)
+		}
+		if before := tokens.BeforeDashDash(); len(before) != 0 {
+			t.Errorf("%#v.BeforeDashDash() -> %#v. Want: empty", tokens, before)
+		}
+	}
+}
+
+func TestFlags(t *testing.T) {
+	tests := []struct {
+		s           string
+		flags       []string
+		positionals []string
+		terminator  bool
+	}{
+		{`ls -la --color=auto dir`, []string{"-la", "--color=auto"}, []string{"dir"}, false},
+		{`head "-n" 3 -`, []string{"-n"}, []string{"3", "-"}, false},
+		{`git checkout -b x -- file -f`, []string{"-b"}, []string{"checkout", "x", "file", "-f"}, true},
+		{`A=1 >out cmd -v arg `, []string{"-v"}, []string{"arg", ""}, false},
+		{`cat a | grep -`, []string{}, []string{"-"}, false},
+		{`grep -`, []string{}, []string{"-"}, false},
+		{`grep --`, []string{}, []string{}, true},
+	}
+	for _, test := range tests {
+		tokens, err := Split(test.s)
+		if err != nil {
+			t.Error(err)
+		}
+		args := tokens.Flags()
+		if !reflect.DeepEqual(args.Flags.Strings(), test.flags) ||
+			!reflect.DeepEqual(args.Positionals.Strings(), test.positionals) ||
+			(args.Terminator != nil) != test.terminator ||
+			(args.Terminator != nil && args.Terminator.Value != "--") {
+			t.Errorf("Split(%q).Flags() -> %#v, %#v, %v", test.s, args.Flags.Strings(), args.Positionals.Strings(), args.Terminator != nil)
+		}
+	}
+}
+
+func TestCurrentWord(t *testing.T) {
+	tests := []struct {
+		s     string
+		word  string // "-" for none
+		index int
+	}{
+		{`git commit -m "x" `, "", 4},
+		{`git commit -m "x`, `"x`, 3},
+		{`git a|b`, "b", 0},
+		{`a && `, "", 0},
+		{`a >out b`, "b", 2},
+		{``, "", 0},
+	}
+	for _, test := range tests {
+		tokens, err := Split(test.s)
+		if err != nil {
+			t.Error(err)
+		}
+		word := "-"
+		if token := tokens.LastWord(); token != nil {
+			word = token.RawValue
+		}
+		if index := tokens.CurrentWordIndex(); word != test.word || index != test.index {
+			t.Errorf("Split(%q) -> %#v, %v. Want: %#v, %v", test.s, word, index, test.word, test.index)
+		}
+	}
+
+	if word, index := (TokenSlice{}).LastWord(), (TokenSlice{}).CurrentWordIndex(); word != nil || index != -1 {
+		t.Errorf("TokenSlice{} -> %#v, %v", word, index)
+	}
+}
+
+func TestReplaceWord(t *testing.T) {
+	tests := []struct {
+		s     string
+		value string
+		want  string
+	}{
+		{`git commit -m 'work in prog`, "work in progress", `git commit -m 'work in progress'`},
+		{`git commit -m "work in prog`, `work "in" $progress`, `git commit -m "work \"in\" \$progress"`},
+		{`echo $'a\tb`, "a'b\\c", `echo $'a\'b\\c'`},
+		{`echo  'it`, "it's", `echo  'it'\''s'`},
+		{`ls my\ fi`, "my file (1).txt", `ls my\ file\ \(1\).txt`},
+		{`ls `, "", `ls ''`},
+		{`cat a |  gre`, "grep", `cat a |  grep`},
+		{`ls  pre # comment`, "prefix", `ls  prefix`},
+	}
+	for _, test := range tests {
+		tokens, err := Split(test.s)
+		if err != nil {
+			t.Error(err)
+		}
+		words := tokens.Words()
+		got, err := words.ReplaceWord(len(words)-1, test.value)
+		if err != nil {
+			t.Error(err)
+		}
+		if got != test.want {
+			t.Errorf("Split(%q).Words().ReplaceWord(%q) -> %q. Want: %q", test.s, test.value, got, test.want)
+		}
+		if words, err := Split(got); err != nil || words.Words()[len(words.Words())-1].Value != test.value {
+			t.Errorf("Split(%q) -> %#v, %v. Want value: %q", got, words.Strings(), err, test.value)
+		}
+	}
+
+	if _, err := (TokenSlice{}).ReplaceWord(0, ""); err == nil {
+		t.Error("ReplaceWord() should fail for out of range index")
+	}
+}
+
+func TestReconstruct(t *testing.T) {
+	for _, s := range []string{
+		testString,
+		"",
+		"  echo\t'a b'  \"c\\\"d\" ",
+		"echo a # comment\n\necho b",
+		"cat <<EOF # c\n a\nEOF\n",
+		"a\\\nb $'c\\n' `id` $(date) ~/* 2>&1 && (cd /tmp; ls) | wc -l &",
+		"echo 'unclosed",
+	} {
+		tokens, err := Split(s)
+		if err != nil {
+			t.Error(err)
+		}
+		if got := tokens.Reconstruct(); got != s {
+			t.Errorf("Split(%q).Reconstruct() -> %q", s, got)
+		}
+	}
+
+	s := "echo a # comment"
+	tokens, err := Tokenize(s)
+	if err != nil {
+		t.Error(err)
+	}
+	if got := tokens.Reconstruct(); got != s {
+		t.Errorf("Tokenize(%q).Reconstruct() -> %q", s, got)
+	}
+}
+
+type limitedWriter struct {
+	limit   int
+	written strings.Builder
+}
+
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	if w.written.Len()+len(p) > w.limit {
+		n, _ := w.written.Write(p[:w.limit-w.written.Len()])
+		return n, io.ErrShortWrite
+	}
+	return w.written.Write(p)
+}
+
+func TestWriteValues(t *testing.T) {
+	tokens, err := Split(`echo "a b" c`)
+	if err != nil {
+		t.Error(err)
+	}
+
+	var w strings.Builder
+	if n, err := tokens.WriteValues(&w, ", "); err != nil || n != 12 || w.String() != "echo, a b, c" {
+		t.Errorf("WriteValues() -> %v, %v, %q", n, err, w.String())
+	}
+
+	w.Reset()
+	if n, err := tokens.WriteValuesNul(&w); err != nil || n != 10 || w.String() != "echo\x00a b\x00c" {
+		t.Errorf("WriteValuesNul() -> %v, %v, %q", n, err, w.String())
+	}
+
+	limited := &limitedWriter{limit: 6}
+	if n, err := tokens.WriteValues(limited, " "); err != io.ErrShortWrite || n != 6 || limited.written.String() != "echo a" {
+		t.Errorf("WriteValues() -> %v, %v, %q", n, err, limited.written.String())
+	}
+}
@@ -0,0 +1,104 @@
+package shlex
+
+import "strings"
+
+// SplitWindows partitions a command line into arguments like CommandLineToArgvW and the Microsoft C runtime,
+// which differs entirely from POSIX shells:
+//   - arguments are separated by spaces and tabs
+//   - double quotes group spaces into an argument (single quotes are literal)
+//   - a doubled double quote within quotes is a literal double quote
+//   - backslashes are literal unless they precede a double quote: 2n backslashes followed by a double quote
+//     result in n backslashes and a quote delimiter, 2n+1 backslashes in n backslashes and a literal double quote
+//
+// An unclosed quote is closed by the end of the input, unless WithStrict is given which returns an UnclosedQuoteError.
+// Other options have no effect. The program name (first argument) is split like any other argument.
+func SplitWindows(s string, opts ...Option) ([]string, error) {
+	c := newConfig(opts...)
+	args := make([]string, 0)
+	var arg strings.Builder
+	inArg := false
+	quoted := false
+	quoteIndex := 0
+	backslashes := 0
+
+	runes := []rune(s)
+	for index := 0; index < len(runes); index++ {
+		switch r := runes[index]; {
+		case r == '\\':
+			backslashes += 1
+			inArg = true
+			continue
+		case r == '"':
+			arg.WriteString(strings.Repeat(`\`, backslashes/2))
+			switch {
+			case backslashes%2 == 1:
+				arg.WriteRune('"') // escaped quote
+			case quoted && index+1 < len(runes) && runes[index+1] == '"':
+				arg.WriteRune('"') // doubled quote within quotes
+				index += 1
+			default:
+				quoted = !quoted
+				quoteIndex = index
+			}
+			inArg = true
+		case !quoted && (r == ' ' || r == '\t'):
+			arg.WriteString(strings.Repeat(`\`, backslashes))
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+			}
+			inArg = false
+		default:
+			arg.WriteString(strings.Repeat(`\`, backslashes))
+			arg.WriteRune(r)
+			inArg = true
+		}
+		backslashes = 0
+	}
+	arg.WriteString(strings.Repeat(`\`, backslashes))
+	if inArg {
+		args = append(args, arg.String())
+	}
+
+	if quoted && c.strict {
+		return nil, &UnclosedQuoteError{Index: quoteIndex, Quote: '"'}
+	}
+	return args, nil
+}
+
+// JoinWindows concatenates arguments to a command line which SplitWindows (and CommandLineToArgvW) splits into the same arguments.
+// Arguments are only quoted where necessary. This does not escape runes special to cmd.exe (see CMD_DIALECT).
+func JoinWindows(args []string) string {
+	formatted := make([]string, 0, len(args))
+	for _, arg := range args {
+		formatted = append(formatted, quoteWindows(arg))
+	}
+	return strings.Join(formatted, " ")
+}
+
+// quoteWindows quotes an argument for the command line of a Windows program.
+func quoteWindows(arg string) string {
+	if arg != "" && !strings.ContainsAny(arg, " \t\n\v\"") {
+		return arg
+	}
+
+	var quoted strings.Builder
+	quoted.WriteRune('"')
+	backslashes := 0
+	for _, r := range arg {
+		switch r {
+		case '\\':
+			backslashes += 1
+			continue
+		case '"':
+			quoted.WriteString(strings.Repeat(`\`, backslashes*2+1)) // escape the backslashes and the quote
+		default:
+			quoted.WriteString(strings.Repeat(`\`, backslashes))
+		}
+		quoted.WriteRune(r)
+		backslashes = 0
+	}
+	quoted.WriteString(strings.Repeat(`\`, backslashes*2)) // backslashes precede the closing quote
+	quoted.WriteRune('"')
+	return quoted.String()
+}
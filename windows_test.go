@@ -0,0 +1,76 @@
+package shlex
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"testing/quick"
+)
+
+func TestSplitWindows(t *testing.T) {
+	tests := map[string][]string{
+		// https://learn.microsoft.com/en-us/cpp/c-language/parsing-c-command-line-arguments
+		`"a b c" d e`:         {"a b c", "d", "e"},
+		`"ab\"c" "\\" d`:      {`ab"c`, `\`, "d"},
+		`a\\\b d"e f"g h`:     {`a\\\b`, "de fg", "h"},
+		`a\\\"b c d`:          {`a\"b`, "c", "d"},
+		`a\\\\"b c" d e`:      {`a\\b c`, "d", "e"},
+		`a"b"" c d`:           {`ab" c d`},
+		`"a b"" c"`:           {`a b" c`},
+		``:                    {},
+		`  a  `:               {"a"},
+		`""`:                  {""},
+		`a "" b`:              {"a", "", "b"},
+		`'a b'`:               {"'a", "b'"},
+		`C:\dir\ file`:        {`C:\dir\`, "file"},
+		`"C:\Program Files\"`: {`C:\Program Files"`},
+		"a\tb\nc":             {"a", "b\nc"},
+		`"unclosed arg`:       {"unclosed arg"},
+	}
+	for s, want := range tests {
+		got, err := SplitWindows(s)
+		if err != nil {
+			t.Error(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("SplitWindows(%#q) -> %#v. Want: %#v", s, got, want)
+		}
+	}
+
+	var quoteError *UnclosedQuoteError
+	if _, err := SplitWindows(`a "b c`, WithStrict(true)); !errors.As(err, &quoteError) || quoteError.Index != 2 {
+		t.Errorf("SplitWindows() in strict mode -> %#v. Want: UnclosedQuoteError", err)
+	}
+}
+
+func TestJoinWindows(t *testing.T) {
+	args := []string{"plain", "", "a b", `ab"c`, `\`, `a\\\b`, `C:\Program Files\`, `"`, "tab\there"}
+	want := `plain "" "a b" "ab\"c" \ a\\\b "C:\Program Files\\" "\"" "tab` + "\t" + `here"`
+	if got := JoinWindows(args); got != want {
+		t.Errorf("JoinWindows(%#v) -> %#q. Want: %#q", args, got, want)
+	}
+
+	roundtrip := func(args []string) bool {
+		got, err := SplitWindows(JoinWindows(args))
+		return err == nil && reflect.DeepEqual(got, args)
+	}
+	if err := quick.Check(roundtrip, nil); err != nil {
+		t.Error(err)
+	}
+
+	alphabet := []rune(" \t\\\"'ab")
+	windowsish := func(indexes [][]uint8) bool {
+		args := make([]string, 0, len(indexes))
+		for _, arg := range indexes {
+			runes := make([]rune, 0, len(arg))
+			for _, index := range arg {
+				runes = append(runes, alphabet[int(index)%len(alphabet)])
+			}
+			args = append(args, string(runes))
+		}
+		return roundtrip(args)
+	}
+	if err := quick.Check(windowsish, &quick.Config{MaxCount: 10000}); err != nil {
+		t.Error(err)
+	}
+}
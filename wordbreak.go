@@ -1,9 +1,20 @@
 package shlex
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"os"
+)
 
 const BASH_WORDBREAKS = " \t\r\n" + `"'><=;|&(:`
 
+// wordbreaks returns the runes of the COMP_WORDBREAKS environment variable (default: BASH_WORDBREAKS).
+func wordbreaks() string {
+	if wordbreaks := os.Getenv("COMP_WORDBREAKS"); wordbreaks != "" {
+		return wordbreaks
+	}
+	return BASH_WORDBREAKS
+}
+
 type WordbreakType int
 
 const (
@@ -27,6 +38,13 @@ const (
 	WORDBREAK_LIST_OR
 	// COMP_WORDBREAKS
 	WORDBREAK_CUSTOM
+	// https://www.gnu.org/software/bash/manual/html_node/Redirections.html
+	WORDBREAK_REDIRECT_INPUT_HEREDOC
+	WORDBREAK_REDIRECT_OUTPUT_CLOBBER
+	// https://www.gnu.org/software/bash/manual/html_node/Conditional-Constructs.html
+	WORDBREAK_CASE_BREAK
+	WORDBREAK_CASE_FALLTHROUGH
+	WORDBREAK_CASE_CONTINUE
 )
 
 var wordbreakTypes = map[WordbreakType]string{
@@ -46,12 +64,31 @@ var wordbreakTypes = map[WordbreakType]string{
 	WORDBREAK_LIST_AND:                    "WORDBREAK_LIST_AND",
 	WORDBREAK_LIST_OR:                     "WORDBREAK_LIST_OR",
 	WORDBREAK_CUSTOM:                      "WORDBREAK_CUSTOM",
+	WORDBREAK_REDIRECT_INPUT_HEREDOC:      "WORDBREAK_REDIRECT_INPUT_HEREDOC",
+	WORDBREAK_REDIRECT_OUTPUT_CLOBBER:     "WORDBREAK_REDIRECT_OUTPUT_CLOBBER",
+	WORDBREAK_CASE_BREAK:                  "WORDBREAK_CASE_BREAK",
+	WORDBREAK_CASE_FALLTHROUGH:            "WORDBREAK_CASE_FALLTHROUGH",
+	WORDBREAK_CASE_CONTINUE:               "WORDBREAK_CASE_CONTINUE",
 }
 
 func (w WordbreakType) MarshalJSON() ([]byte, error) {
 	return json.Marshal(wordbreakTypes[w])
 }
 
+func (w *WordbreakType) UnmarshalJSON(data []byte) error {
+	i, err := unmarshalEnum(data, func(s string) (int, bool) {
+		for wordbreakType, name := range wordbreakTypes {
+			if name == s {
+				return int(wordbreakType), true
+			}
+		}
+		return 0, false
+	})
+	*w = WordbreakType(i)
+	return err
+}
+
+// IsPipelineDelimiter reports whether the wordbreak separates commands (`|`, `|&`, `&`, `;`, `&&`, `||`, `;;`, `;&`, `;;&`).
 func (w WordbreakType) IsPipelineDelimiter() bool {
 	switch w {
 	case
@@ -60,13 +97,35 @@ func (w WordbreakType) IsPipelineDelimiter() bool {
 		WORDBREAK_LIST_ASYNC,
 		WORDBREAK_LIST_SEQUENTIAL,
 		WORDBREAK_LIST_AND,
-		WORDBREAK_LIST_OR:
+		WORDBREAK_LIST_OR,
+		WORDBREAK_CASE_BREAK,
+		WORDBREAK_CASE_FALLTHROUGH,
+		WORDBREAK_CASE_CONTINUE:
 		return true
 	default:
 		return false
 	}
 }
 
+// IsCaseTerminator reports whether the wordbreak terminates a clause of a case statement (`;;`, `;&`, `;;&`).
+func (w WordbreakType) IsCaseTerminator() bool {
+	switch w {
+	case
+		WORDBREAK_CASE_BREAK,
+		WORDBREAK_CASE_FALLTHROUGH,
+		WORDBREAK_CASE_CONTINUE:
+		return true
+	default:
+		return false
+	}
+}
+
+// IsPipe reports whether the wordbreak connects commands of a pipeline (`|`, `|&`).
+func (w WordbreakType) IsPipe() bool {
+	return w == WORDBREAK_PIPE || w == WORDBREAK_PIPE_WITH_STDERR
+}
+
+// IsRedirect reports whether the wordbreak is a redirection operator.
 func (w WordbreakType) IsRedirect() bool {
 	switch w {
 	case
@@ -77,7 +136,9 @@ func (w WordbreakType) IsRedirect() bool {
 		WORDBREAK_REDIRECT_OUTPUT_BOTH_APPEND,
 		WORDBREAK_REDIRECT_INPUT_STRING,
 		WORDBREAK_REDIRECT_INPUT_DUPLICATE,
-		WORDBREAK_REDIRECT_INPUT_OUTPUT:
+		WORDBREAK_REDIRECT_INPUT_OUTPUT,
+		WORDBREAK_REDIRECT_INPUT_HEREDOC,
+		WORDBREAK_REDIRECT_OUTPUT_CLOBBER:
 		return true
 	default:
 		return false
@@ -86,7 +147,7 @@ func (w WordbreakType) IsRedirect() bool {
 }
 
 func wordbreakType(t Token) WordbreakType {
-	switch t.RawValue {
+	switch t.redirectOperator() {
 	case "<":
 		return WORDBREAK_REDIRECT_INPUT
 	case ">":
@@ -103,6 +164,10 @@ func wordbreakType(t Token) WordbreakType {
 		return WORDBREAK_REDIRECT_INPUT_DUPLICATE
 	case "<>":
 		return WORDBREAK_REDIRECT_INPUT_OUTPUT
+	case "<<", "<<-":
+		return WORDBREAK_REDIRECT_INPUT_HEREDOC
+	case ">|":
+		return WORDBREAK_REDIRECT_OUTPUT_CLOBBER
 	case "|":
 		return WORDBREAK_PIPE
 	case "|&":
@@ -111,6 +176,12 @@ func wordbreakType(t Token) WordbreakType {
 		return WORDBREAK_LIST_ASYNC
 	case ";":
 		return WORDBREAK_LIST_SEQUENTIAL
+	case ";;":
+		return WORDBREAK_CASE_BREAK
+	case ";&":
+		return WORDBREAK_CASE_FALLTHROUGH
+	case ";;&":
+		return WORDBREAK_CASE_CONTINUE
 	case "&&":
 		return WORDBREAK_LIST_AND
 	case "||":